@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ShuffleBag is a random permutation of the enabled wallpapers that is used up
+// one wallpaper at a time, so every wallpaper is shown once per cycle.
+type ShuffleBag struct {
+	// Ids not yet drawn this cycle, in the order they will be drawn
+	Remaining []string `json:"remaining"`
+	// Ids already drawn this cycle
+	Drawn []string `json:"drawn"`
+}
+
+func (w *Walls) bagPath() string {
+	return filepath.Join(w.Config.Storage.Sources, "bag.json")
+}
+
+func (w *Walls) loadBag(ctx context.Context) (*ShuffleBag, error) {
+	data, err := os.ReadFile(w.bagPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return &ShuffleBag{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading shuffle bag: %w", err)
+	}
+	var bag ShuffleBag
+	if err := json.Unmarshal(data, &bag); err != nil {
+		return nil, fmt.Errorf("parsing shuffle bag %s: %w", w.bagPath(), err)
+	}
+	return &bag, nil
+}
+
+func (w *Walls) writeBag(ctx context.Context, bag *ShuffleBag) error {
+	data, err := json.Marshal(bag)
+	if err != nil {
+		return fmt.Errorf("marshalling shuffle bag: %w", err)
+	}
+	if err := writeFileAtomic(w.bagPath(), data, 0644); err != nil {
+		return fmt.Errorf("writing shuffle bag: %w", err)
+	}
+	return nil
+}
+
+// sync brings the bag up to date with the enabled wallpapers: deleted and
+// disabled wallpapers are dropped, and wallpapers added (or enabled) since the
+// cycle started are inserted at random positions.
+func (bag *ShuffleBag) sync(enabled []string) {
+	isEnabled := make(map[string]bool, len(enabled))
+	for _, id := range enabled {
+		isEnabled[id] = true
+	}
+	drop := func(id string) bool { return !isEnabled[id] }
+	bag.Remaining = slices.DeleteFunc(bag.Remaining, drop)
+	bag.Drawn = slices.DeleteFunc(bag.Drawn, drop)
+
+	for _, id := range enabled {
+		if slices.Contains(bag.Remaining, id) || slices.Contains(bag.Drawn, id) {
+			continue
+		}
+		bag.Remaining = slices.Insert(bag.Remaining, rand.IntN(len(bag.Remaining)+1), id)
+	}
+}
+
+func (bag *ShuffleBag) reshuffle(enabled []string) {
+	bag.Remaining = slices.Clone(enabled)
+	rand.Shuffle(len(bag.Remaining), func(i, j int) {
+		bag.Remaining[i], bag.Remaining[j] = bag.Remaining[j], bag.Remaining[i]
+	})
+	bag.Drawn = []string{}
+}
+
+// draw removes and returns the first remaining id in candidates, or "" if
+// there is none.
+func (bag *ShuffleBag) draw(candidates map[string]bool) string {
+	for i, id := range bag.Remaining {
+		if candidates[id] {
+			bag.Remaining = slices.Delete(bag.Remaining, i, i+1)
+			bag.Drawn = append(bag.Drawn, id)
+			return id
+		}
+	}
+	return ""
+}
+
+// shufflePick draws the next wallpaper among candidates from the shuffle bag,
+// starting a new cycle when no candidate is left in the bag.
+func (w *Walls) shufflePick(ctx context.Context, candidates []*Wallpaper) (*Wallpaper, error) {
+	bag, err := w.loadBag(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var enabled []string
+	for _, wp := range w.Store.Wallpapers {
+		if wp.Enabled {
+			enabled = append(enabled, wp.Id)
+		}
+	}
+	bag.sync(enabled)
+
+	isCandidate := make(map[string]bool, len(candidates))
+	for _, wp := range candidates {
+		isCandidate[wp.Id] = true
+	}
+
+	id := bag.draw(isCandidate)
+	if id == "" {
+		logger.Debugf("shuffle bag exhausted, reshuffling %d wallpapers", len(enabled))
+		bag.reshuffle(enabled)
+		id = bag.draw(isCandidate)
+	}
+
+	if err := w.writeBag(ctx, bag); err != nil {
+		return nil, err
+	}
+	return w.FindWallpaper(id), nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -48,8 +50,14 @@ func main() {
 				// skip loading config for completion
 				return ctx, nil
 			}
+			if sub := cmd.Command(subcommand); sub != nil {
+				// resolve aliases
+				subcommand = sub.Name
+			}
 
-			return loadWalls(ctx, cmd.String("config"))
+			// the store command migrates explicitly
+			migrate := subcommand != "store"
+			return loadWalls(ctx, cmd.String("config"), migrate, commandLocks[subcommand])
 		},
 		Commands: []*cli.Command{
 			addCommand(),
@@ -57,6 +65,21 @@ func main() {
 			listCommand(),
 			deleteCommand(),
 			setCommand(),
+			fsckCommand(),
+			storeCommand(),
+			tagCommand(),
+			enableCommand(),
+			disableCommand(),
+			currentCommand(),
+			prevCommand(),
+			nextCommand(),
+			historyCommand(),
+			weightCommand(),
+			rateCommand(),
+			favCommand(),
+			statsCommand(),
+			watchCommand(),
+			retagCommand(),
 		},
 		EnableShellCompletion: true,
 		ConfigureShellCompletionCommand: func(cmd *cli.Command) {
@@ -80,6 +103,32 @@ func main() {
 	}
 }
 
+// wallpaperMatcher builds a matcher from the --tag and --filter flags of cmd,
+// returning nil if neither is set.
+func wallpaperMatcher(cmd *cli.Command) (func(*Wallpaper) bool, error) {
+	tags, err := tagMatcher(cmd.StringSlice("tag"))
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseFilterFlag(cmd.String("filter"))
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return tags, nil
+	}
+	return matchAll(tags, filter.Match), nil
+}
+
+func printJSON(v any) error {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
 func forwardUsageError(ctx context.Context, cmd *cli.Command, err error, isSubcommand bool) error {
 	return err
 }
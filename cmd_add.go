@@ -2,7 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/urfave/cli/v3"
 )
@@ -11,23 +17,58 @@ func addCommand() *cli.Command {
 	return &cli.Command{
 		Name:         "add",
 		Aliases:      []string{"a"},
-		Usage:        "Add a wallpaper to the store",
+		Usage:        "Add wallpapers to the store",
+		UsageText:    "walls add [options] <file|directory|glob|url|->...",
 		HideHelp:     true,
 		OnUsageError: forwardUsageError,
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name: "no-precache",
-				Usage: "Skips precaching any wallpaper effects for the new wallpaper. This is useful if you are " +
-					"adding multiple wallpapers and want to precache them in parallel using the precache command.",
+				Usage: "Skips precaching any wallpaper effects for the new wallpapers (thumbnails of the originals are still " +
+					"generated). This is useful if you want to precache them later using the precache command.",
 			},
 			&cli.StringFlag{
 				Name:  "id",
-				Usage: "The ID of the new wallpaper. If not specified, an ID derived from the filename will be used.",
+				Usage: "The ID of the new wallpaper when adding a single file. If not specified, an ID derived from the filename will be used.",
+			},
+			&cli.BoolFlag{
+				Name:  "allow-duplicate",
+				Usage: "Add wallpapers even if an identical image is already in the store.",
+			},
+			&cli.BoolFlag{
+				Name:    "recursive",
+				Aliases: []string{"r"},
+				Usage:   "Also add images in subdirectories of the given directories.",
+			},
+			&cli.StringFlag{
+				Name:  "on-conflict",
+				Usage: "What to do when the ID derived from a filename is taken: error, suffix (name-2) or hash (name-<hash>). Defaults to import.on-conflict from the config.",
+			},
+			&cli.BoolFlag{
+				Name:  "no-validate",
+				Usage: "Add images even if they are rejected by the import.validate policy in the config.",
+			},
+			&cli.StringFlag{
+				Name:  "mode",
+				Usage: "How to import files: copy, hardlink, symlink or reference (leave the file in place). Defaults to import.mode from the config.",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "Maximum time to spend downloading each URL (0 for no limit).",
+				Value: 2 * time.Minute,
+			},
+			&cli.StringFlag{
+				Name:  "max-size",
+				Usage: "Maximum size of a downloaded image or an image read from stdin, e.g. 50M (0 for no limit).",
+				Value: "100M",
 			},
 		},
 		Arguments: []cli.Argument{
-			&cli.StringArg{
-				Name: "wallpaper",
+			&cli.StringArgs{
+				Name:      "wallpapers",
+				UsageText: "Image files, directories of images, glob patterns, HTTP(S) URLs, or - to read an image from stdin.",
+				Min:       0,
+				Max:       -1,
 			},
 		},
 		Action: addAction,
@@ -35,32 +76,85 @@ func addCommand() *cli.Command {
 }
 
 func addAction(ctx context.Context, cmd *cli.Command) error {
-	wallpaper := cmd.StringArg("wallpaper")
-	if wallpaper == "" {
-		return fmt.Errorf("wallpaper is required\nusage: walls add <wallpaper>")
+	paths := cmd.StringArgs("wallpapers")
+	if len(paths) == 0 {
+		return fmt.Errorf("wallpaper is required\nusage: %s", cmd.UsageText)
 	}
 
-	w := getWalls(ctx)
-	defer w.Sync(ctx)
+	if slices.Contains(paths, "-") {
+		if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("refusing to read an image from a terminal; pipe one into walls add -")
+		}
+	}
 
-	wp, err := w.AddWallpaper(ctx, wallpaper, cmd.String("id"))
-	if err != nil {
-		return fmt.Errorf("adding wallpaper: %w", err)
+	mode := cmd.String("mode")
+	if mode != "" && !slices.Contains(importModes, mode) {
+		return fmt.Errorf("--mode: unknown import mode %s (available: %s)", mode, strings.Join(importModes, ", "))
 	}
 
-	if cmd.Bool("no-precache") {
-		logger.Infof("wallpaper %s added, precaching skipped", wp.Id)
-		return nil
+	onConflict := cmd.String("on-conflict")
+	if onConflict != "" && !slices.Contains(conflictPolicies, onConflict) {
+		return fmt.Errorf("--on-conflict: unknown policy %s (available: %s)", onConflict, strings.Join(conflictPolicies, ", "))
 	}
 
-	logger.Infof("wallpaper %s added, precaching %d effects...", wp.Id, len(w.Config.Effects.Effects))
+	maxSize, err := parseByteSize(cmd.String("max-size"))
+	if err != nil {
+		return fmt.Errorf("--max-size: %w", err)
+	}
 
-	err = w.precacheWallpaper(ctx, wp, cmd.Bool("force"))
+	w := getWalls(ctx)
+	result, err := w.ImportPaths(ctx, paths, ImportOptions{
+		AddOptions: AddOptions{
+			Id:             cmd.String("id"),
+			AllowDuplicate: cmd.Bool("allow-duplicate"),
+			Mode:           mode,
+			SkipValidation: cmd.Bool("no-validate"),
+			OnConflict:     onConflict,
+		},
+		Recursive:    cmd.Bool("recursive"),
+		Stdin:        os.Stdin,
+		StdinMaxSize: maxSize,
+		Downloader: &Downloader{
+			Dir:     filepath.Join(w.Config.Storage.Cache, downloadsCacheDir),
+			MaxSize: maxSize,
+			Timeout: cmd.Duration("timeout"),
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("precaching wallpaper: %w", err)
+		return err
+	}
+
+	rejected := false
+	for _, issue := range result.Skipped {
+		logger.Warnf("skipped %s: %s", issue.Path, issue.Reason)
+		var invalid *ValidationError
+		rejected = rejected || errors.As(issue.Reason, &invalid)
+	}
+	if rejected {
+		logger.Infof("specify --no-validate to add images rejected by the import policy anyway")
+	}
+	for _, issue := range result.Failed {
+		logger.Errorf("adding %s: %w", issue.Path, issue.Reason)
+	}
+	if len(result.Added) == 1 {
+		logger.Infof("wallpaper %s added", result.Added[0].Id)
 	}
+	logger.Infof("%d added, %d skipped, %d failed", len(result.Added), len(result.Skipped), len(result.Failed))
 
-	logger.Infof("precaching complete")
+	if len(result.Added) > 0 && !cmd.Bool("no-precache") {
+		if err := w.PrecacheWallpapers(ctx, result.Added, false); err != nil {
+			return fmt.Errorf("precaching wallpapers: %w", err)
+		}
+	} else {
+		// precaching generates thumbnails as well
+		w.ThumbnailWallpapers(ctx, result.Added, false)
+	}
 
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("failed to add %d files", len(result.Failed))
+	}
+	if len(result.Added) == 0 {
+		return fmt.Errorf("no wallpapers added")
+	}
 	return nil
 }
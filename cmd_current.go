@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func currentCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "current",
+		Aliases:      []string{"cur"},
+		Usage:        "Show the current wallpaper",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:    "long",
+				Aliases: []string{"l"},
+				Usage:   "Show extended information about the current wallpaper.",
+			},
+			&cli.BoolFlag{
+				Name:    "json",
+				Aliases: []string{"j"},
+				Usage:   "Output the current wallpaper in JSON format.",
+			},
+		},
+		Action: currentAction,
+	}
+}
+
+func currentAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+
+	state, err := w.State(ctx)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no wallpaper has been set")
+	}
+
+	if cmd.Bool("json") {
+		return printJSON(state)
+	}
+
+	if !cmd.Bool("long") {
+		fmt.Println(state.Id)
+		return nil
+	}
+
+	fmt.Printf("Wallpaper %s:\n", state.Id)
+	if state.Effect != "" {
+		fmt.Printf("  Effect: %s\n", state.Effect)
+	}
+	fmt.Printf("  Path: %s\n", state.Path)
+	fmt.Printf("  Set at: %s (%s ago)\n", state.SetAt.Format(time.DateTime), time.Since(state.SetAt).Round(time.Second))
+	fmt.Printf("  Processes: %v\n", state.Pids)
+	if w.FindWallpaper(state.Id) == nil {
+		fmt.Printf("  (no longer in the store)\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+)
+
+func enableCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "enable",
+		Aliases:      []string{"en"},
+		Usage:        "Allow wallpapers to be picked randomly",
+		UsageText:    "walls enable <wallpaper|glob>...",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Arguments: []cli.Argument{
+			&cli.StringArgs{
+				Name:      "wallpapers",
+				UsageText: "IDs of wallpapers or glob patterns matching IDs (e.g. 'forest-*').",
+				Min:       0,
+				Max:       -1,
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			return setEnabledAction(ctx, cmd, true)
+		},
+	}
+}
+
+func disableCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "disable",
+		Aliases:      []string{"dis"},
+		Usage:        "Prevent wallpapers from being picked randomly",
+		UsageText:    "walls disable <wallpaper|glob>...",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Arguments: []cli.Argument{
+			&cli.StringArgs{
+				Name:      "wallpapers",
+				UsageText: "IDs of wallpapers or glob patterns matching IDs (e.g. 'forest-*').",
+				Min:       0,
+				Max:       -1,
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			return setEnabledAction(ctx, cmd, false)
+		},
+	}
+}
+
+func setEnabledAction(ctx context.Context, cmd *cli.Command, enabled bool) error {
+	patterns := cmd.StringArgs("wallpapers")
+	if len(patterns) == 0 {
+		return fmt.Errorf("at least one wallpaper is required\nusage: %s", cmd.UsageText)
+	}
+
+	w := getWalls(ctx)
+	wps, err := w.MatchWallpapers(patterns)
+	if err != nil {
+		return err
+	}
+	defer w.Sync(ctx)
+
+	changed := 0
+	for _, wp := range wps {
+		if wp.Enabled != enabled {
+			wp.Enabled = enabled
+			changed++
+		}
+	}
+
+	state := "enabled"
+	if !enabled {
+		state = "disabled"
+	}
+	logger.Infof("%s %d wallpapers (%d already %s)", state, changed, len(wps)-changed, state)
+	return nil
+}
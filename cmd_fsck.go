@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+)
+
+func fsckCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "fsck",
+		Usage:        "Check the store, sources and cache for inconsistencies",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:    "repair",
+				Aliases: []string{"r"},
+				Usage: "Repair problems found: remove wallpapers with missing files, adopt (or delete duplicate) " +
+					"orphaned sources, remove stale cache files, and update mismatched metadata.",
+			},
+			&cli.BoolFlag{
+				Name:    "json",
+				Aliases: []string{"j"},
+				Usage:   "Output the report in JSON format.",
+			},
+		},
+		Action: fsckAction,
+	}
+}
+
+func fsckAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+	repair := cmd.Bool("repair")
+	if repair {
+		defer w.Sync(ctx)
+	}
+
+	report, err := w.Fsck(ctx, repair)
+	if err != nil {
+		return fmt.Errorf("checking store: %w", err)
+	}
+
+	if cmd.Bool("json") {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range report.Problems {
+			subject := p.Path
+			if p.Id != "" {
+				subject = p.Id + " (" + p.Path + ")"
+			}
+			status := ""
+			if p.Repaired {
+				status = " [repaired]"
+			} else if p.RepairError != "" {
+				status = " [repair failed: " + p.RepairError + "]"
+			}
+			fmt.Printf("%s: %s: %s%s\n", p.Kind, subject, p.Message, status)
+		}
+	}
+
+	if len(report.Problems) == 0 {
+		logger.Infof("no problems found")
+		return nil
+	}
+	if n := report.Unrepaired(); n > 0 {
+		if !repair {
+			return fmt.Errorf("%d problems found (run with --repair to fix them)", n)
+		}
+		return fmt.Errorf("%d of %d problems could not be repaired", n, len(report.Problems))
+	}
+	logger.Infof("%d problems repaired", len(report.Problems))
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func prevCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "prev",
+		Aliases:      []string{"p", "back"},
+		Usage:        "Go back to the previous wallpaper in the history",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Arguments: []cli.Argument{
+			&cli.IntArg{
+				Name:      "count",
+				UsageText: "Number of wallpapers to go back.",
+				Value:     1,
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			return stepHistoryAction(ctx, -cmd.IntArg("count"))
+		},
+	}
+}
+
+func nextCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "next",
+		Aliases:      []string{"n", "forward"},
+		Usage:        "Go forward to the next wallpaper in the history",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Arguments: []cli.Argument{
+			&cli.IntArg{
+				Name:      "count",
+				UsageText: "Number of wallpapers to go forward.",
+				Value:     1,
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			return stepHistoryAction(ctx, cmd.IntArg("count"))
+		},
+	}
+}
+
+func stepHistoryAction(ctx context.Context, steps int) error {
+	if steps == 0 {
+		return fmt.Errorf("count must be at least 1")
+	}
+
+	w := getWalls(ctx)
+	wp, err := w.StepHistory(ctx, steps)
+	if err != nil {
+		return fmt.Errorf("setting wallpaper: %w", err)
+	}
+
+	logger.Infof("wallpaper set to %s", wp.Id)
+	return nil
+}
+
+func historyCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "history",
+		Aliases:      []string{"hist"},
+		Usage:        "List recently set wallpapers, newest first",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "limit",
+				Aliases: []string{"n"},
+				Usage:   "Show at most this many entries (0 for all).",
+			},
+			&cli.BoolFlag{
+				Name:    "json",
+				Aliases: []string{"j"},
+				Usage:   "Output the history in JSON format.",
+			},
+		},
+		Action: historyAction,
+	}
+}
+
+func historyAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+
+	history, err := w.LoadHistory(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Bool("json") {
+		return printJSON(history)
+	}
+
+	limit := cmd.Int("limit")
+	shown := 0
+	for i := len(history.Entries) - 1; i >= 0; i-- {
+		if limit > 0 && shown >= limit {
+			break
+		}
+		entry := history.Entries[i]
+		marker := " "
+		if i == history.Position {
+			marker = "*"
+		}
+		fmt.Printf("%s %s  %s", marker, entry.SetAt.Local().Format(time.DateTime), entry.Id)
+		if entry.Effect != "" {
+			fmt.Printf(" (%s)", entry.Effect)
+		}
+		if w.FindWallpaper(entry.Id) == nil {
+			fmt.Printf(" [deleted]")
+		}
+		fmt.Println()
+		shown++
+	}
+
+	return nil
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/urfave/cli/v3"
 )
@@ -26,26 +29,100 @@ func listCommand() *cli.Command {
 			&cli.BoolFlag{
 				Name:    "json",
 				Aliases: []string{"j"},
-				Usage:   "Output wallpapers in JSON format.",
+				Usage:   "Output wallpapers in JSON format: IDs and source thumbnail paths, or all details including effect thumbnail paths with --long.",
+			},
+			&cli.BoolFlag{
+				Name:  "enabled",
+				Usage: "Only list enabled wallpapers.",
+			},
+			&cli.BoolFlag{
+				Name:  "disabled",
+				Usage: "Only list disabled wallpapers.",
+			},
+			&cli.BoolFlag{
+				Name:  "favorites",
+				Usage: "Only list favorite wallpapers.",
+			},
+			&cli.IntFlag{
+				Name:  "min-rating",
+				Usage: "Only list wallpapers rated at least this many stars.",
+			},
+			&cli.StringFlag{
+				Name:    "sort",
+				Aliases: []string{"s"},
+				Usage:   "Sort wallpapers by one of: added, id, rating, resolution, weight.",
+				Value:   "added",
+			},
+			&cli.BoolFlag{
+				Name:    "reverse",
+				Aliases: []string{"r"},
+				Usage:   "Reverse the sort order.",
+			},
+			&cli.StringSliceFlag{
+				Name:    "tag",
+				Aliases: []string{"t"},
+				Usage:   "Only list wallpapers with this tag (key or key=value). Can be repeated.",
+			},
+			&cli.StringFlag{
+				Name:    "filter",
+				Aliases: []string{"f"},
+				Usage:   "Only list wallpapers matching a filter expression, e.g. 'tag:nature and width>=2560 and not disabled'.",
 			},
 		},
 		Action: listAction,
 	}
 }
 
+// listIdEntry is a wallpaper in the output of list --json. The thumbnail is
+// included so pickers can show previews without a second call.
+type listIdEntry struct {
+	Id        string `json:"id"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// listEntry is a wallpaper in the output of list --long --json.
+type listEntry struct {
+	*Wallpaper
+	Thumbnails Thumbnails `json:"thumbnails"`
+}
+
 func listAction(ctx context.Context, cmd *cli.Command) error {
 	w := getWalls(ctx)
 
-	wps := w.Store.Wallpapers
+	match, err := wallpaperMatcher(cmd)
+	if err != nil {
+		return err
+	}
+	if cmd.Bool("enabled") && cmd.Bool("disabled") {
+		return fmt.Errorf("--enabled and --disabled cannot be used together")
+	}
+	if cmd.Bool("enabled") {
+		match = matchAll(match, func(wp *Wallpaper) bool { return wp.Enabled })
+	} else if cmd.Bool("disabled") {
+		match = matchAll(match, func(wp *Wallpaper) bool { return !wp.Enabled })
+	}
+	if cmd.Bool("favorites") {
+		match = matchAll(match, func(wp *Wallpaper) bool { return wp.Favorite })
+	}
+	if minRating := cmd.Int("min-rating"); minRating > 0 {
+		match = matchAll(match, func(wp *Wallpaper) bool { return wp.Rating >= minRating })
+	}
+	wps := filterWallpapers(w.Store.Wallpapers, match)
+	wps, err = sortWallpapers(wps, cmd.String("sort"), cmd.Bool("reverse"))
+	if err != nil {
+		return err
+	}
 
 	if cmd.Bool("json") {
 		if !cmd.Bool("long") {
-			// ids in array
-			ids := make([]string, len(wps))
+			entries := make([]listIdEntry, len(wps))
 			for i, wp := range wps {
-				ids[i] = wp.Id
+				entries[i] = listIdEntry{Id: wp.Id}
+				if path := wp.ThumbnailPath(ctx, ""); fileExists(path) {
+					entries[i].Thumbnail = path
+				}
 			}
-			out, err := json.Marshal(ids)
+			out, err := json.Marshal(entries)
 			if err != nil {
 				return err
 			}
@@ -53,8 +130,11 @@ func listAction(ctx context.Context, cmd *cli.Command) error {
 			return nil
 		}
 
-		wps := w.Store.Wallpapers
-		out, err := json.Marshal(wps)
+		entries := make([]listEntry, len(wps))
+		for i, wp := range wps {
+			entries[i] = listEntry{wp, w.Thumbnails(ctx, wp)}
+		}
+		out, err := json.Marshal(entries)
 		if err != nil {
 			return err
 		}
@@ -64,7 +144,11 @@ func listAction(ctx context.Context, cmd *cli.Command) error {
 
 	if !cmd.Bool("long") {
 		for _, wp := range wps {
-			fmt.Println(wp.Id)
+			if wp.Enabled {
+				fmt.Println(wp.Id)
+			} else {
+				fmt.Printf("%s (disabled)\n", wp.Id)
+			}
 		}
 		return nil
 	}
@@ -75,7 +159,30 @@ func listAction(ctx context.Context, cmd *cli.Command) error {
 		fmt.Printf("  Original filename: %s\n", wp.OriginalFilename)
 		fmt.Printf("  Resolution: %s\n", wp.Resolution.String())
 		fmt.Printf("  Mime type: %s\n", wp.MimeType)
+		fmt.Printf("  Hash: %s\n", wp.Hash)
 		fmt.Printf("  Enabled: %t\n", wp.Enabled)
+		fmt.Printf("  Weight: %g\n", wp.EffectiveWeight())
+		if wp.Rating > 0 {
+			fmt.Printf("  Rating: %s\n", strings.Repeat("*", wp.Rating))
+		}
+		if wp.Favorite {
+			fmt.Printf("  Favorite: true\n")
+		}
+		if wp.Orientation > OrientationNormal {
+			fmt.Printf("  EXIF orientation: %d\n", wp.Orientation)
+		}
+		if wp.ImportMode() != ImportCopy {
+			fmt.Printf("  Mode: %s\n", wp.ImportMode())
+		}
+		if wp.Source != "" {
+			fmt.Printf("  Source: %s\n", wp.Source)
+		}
+		if len(wp.Tags) > 0 {
+			fmt.Printf("  Tags: %s\n", formatTags(wp.Tags))
+		}
+		if thumb := wp.ThumbnailPath(ctx, ""); fileExists(thumb) {
+			fmt.Printf("  Thumbnail: %s\n", thumb)
+		}
 		if len(w.Config.Effects.Effects) > 0 {
 			fmt.Printf("  Effects:\n")
 			for e, _ := range w.Config.Effects.Effects {
@@ -95,3 +202,48 @@ func listAction(ctx context.Context, cmd *cli.Command) error {
 
 	return nil
 }
+
+// sortWallpapers returns the wallpapers sorted by key. The store keeps
+// wallpapers in the order they were added, so "added" keeps the order as is.
+func sortWallpapers(wps []*Wallpaper, key string, reverse bool) ([]*Wallpaper, error) {
+	var compare func(a, b *Wallpaper) int
+	switch key {
+	case "added", "":
+	case "id":
+		compare = func(a, b *Wallpaper) int { return cmp.Compare(a.Id, b.Id) }
+	case "rating":
+		// highest first, favorites first among equal ratings
+		compare = func(a, b *Wallpaper) int {
+			if c := cmp.Compare(b.Rating, a.Rating); c != 0 {
+				return c
+			}
+			return cmp.Compare(btoi(b.Favorite), btoi(a.Favorite))
+		}
+	case "resolution":
+		// largest first
+		compare = func(a, b *Wallpaper) int {
+			return cmp.Compare(b.Resolution.Width*b.Resolution.Height, a.Resolution.Width*a.Resolution.Height)
+		}
+	case "weight":
+		// heaviest first
+		compare = func(a, b *Wallpaper) int { return cmp.Compare(b.EffectiveWeight(), a.EffectiveWeight()) }
+	default:
+		return nil, fmt.Errorf("unknown sort key %s (available: added, id, rating, resolution, weight)", key)
+	}
+
+	wps = slices.Clone(wps)
+	if compare != nil {
+		slices.SortStableFunc(wps, compare)
+	}
+	if reverse {
+		slices.Reverse(wps)
+	}
+	return wps, nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
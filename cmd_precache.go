@@ -18,6 +18,11 @@ func precacheCommand() *cli.Command {
 				Name:  "force",
 				Usage: "Force precaching of effects even if they have already been precached.",
 			},
+			&cli.StringFlag{
+				Name:    "filter",
+				Aliases: []string{"f"},
+				Usage:   "Only precache wallpapers matching a filter expression, e.g. 'tag:nature and width>=2560 and not disabled'.",
+			},
 		},
 		Arguments: []cli.Argument{
 			&cli.StringArgs{
@@ -29,7 +34,7 @@ func precacheCommand() *cli.Command {
 		},
 		Action: precacheAction,
 		ShellComplete: func(ctx context.Context, cmd *cli.Command) {
-			ctx, err := loadWalls(ctx, cmd.String("config"))
+			ctx, err := loadWalls(ctx, cmd.String("config"), true, lockLoad)
 			if err != nil {
 				return
 			}
@@ -46,7 +51,32 @@ func precacheAction(ctx context.Context, cmd *cli.Command) error {
 	w := getWalls(ctx)
 
 	wpIds := cmd.StringArgs("wallpapers")
-	var err error
+	filter, err := parseFilterFlag(cmd.String("filter"))
+	if err != nil {
+		return err
+	}
+
+	if filter != nil {
+		wps := w.Store.Wallpapers
+		if len(wpIds) > 0 {
+			wps = make([]*Wallpaper, len(wpIds))
+			for i, id := range wpIds {
+				if wps[i] = w.FindWallpaper(id); wps[i] == nil {
+					return fmt.Errorf("wallpaper with id %s not found", id)
+				}
+			}
+		}
+		wps = filterWallpapers(wps, filter.Match)
+		if len(wps) == 0 {
+			logger.Infof("no wallpapers match the filter")
+			return nil
+		}
+		err := w.PrecacheWallpapers(ctx, wps, cmd.Bool("force"))
+		if err != nil {
+			return fmt.Errorf("precaching wallpapers: %w", err)
+		}
+		return nil
+	}
 
 	if len(wpIds) == 0 {
 		err := w.PrecacheAll(ctx, cmd.Bool("force"))
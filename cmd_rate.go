@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/urfave/cli/v3"
+)
+
+func rateCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "rate",
+		Usage:        "Rate wallpapers from 1 to 5 stars (0 removes the rating)",
+		UsageText:    "walls rate <rating> <wallpaper|glob>...",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Arguments: []cli.Argument{
+			&cli.StringArgs{
+				Name: "args",
+				Min:  0,
+				Max:  -1,
+			},
+		},
+		Action: rateAction,
+	}
+}
+
+func rateAction(ctx context.Context, cmd *cli.Command) error {
+	args := cmd.StringArgs("args")
+	if len(args) < 2 {
+		return fmt.Errorf("a rating and at least one wallpaper are required\nusage: %s", cmd.UsageText)
+	}
+	rating, err := strconv.Atoi(args[0])
+	if err != nil || rating < 0 || rating > 5 {
+		return fmt.Errorf("invalid rating %s: must be a number from 1 to 5, or 0 to remove the rating", args[0])
+	}
+
+	w := getWalls(ctx)
+	wps, err := w.MatchWallpapers(args[1:])
+	if err != nil {
+		return err
+	}
+	defer w.Sync(ctx)
+
+	for _, wp := range wps {
+		wp.Rating = rating
+	}
+
+	if rating == 0 {
+		logger.Infof("removed rating of %d wallpapers", len(wps))
+	} else {
+		logger.Infof("rated %d wallpapers %d stars", len(wps), rating)
+	}
+	return nil
+}
+
+func favCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "fav",
+		Aliases:      []string{"favorite"},
+		Usage:        "Mark wallpapers as favorites",
+		UsageText:    "walls fav [--remove] <wallpaper|glob>...",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:    "remove",
+				Aliases: []string{"r"},
+				Usage:   "Unmark the wallpapers as favorites instead.",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArgs{
+				Name:      "wallpapers",
+				UsageText: "IDs of wallpapers or glob patterns matching IDs (e.g. 'forest-*').",
+				Min:       0,
+				Max:       -1,
+			},
+		},
+		Action: favAction,
+	}
+}
+
+func favAction(ctx context.Context, cmd *cli.Command) error {
+	patterns := cmd.StringArgs("wallpapers")
+	if len(patterns) == 0 {
+		return fmt.Errorf("at least one wallpaper is required\nusage: %s", cmd.UsageText)
+	}
+
+	w := getWalls(ctx)
+	wps, err := w.MatchWallpapers(patterns)
+	if err != nil {
+		return err
+	}
+	defer w.Sync(ctx)
+
+	favorite := !cmd.Bool("remove")
+	for _, wp := range wps {
+		wp.Favorite = favorite
+	}
+
+	if favorite {
+		logger.Infof("marked %d wallpapers as favorites", len(wps))
+	} else {
+		logger.Infof("unmarked %d wallpapers as favorites", len(wps))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+func retagCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "retag",
+		Usage:        "Apply the import rules from the config to existing wallpapers",
+		UsageText:    "walls retag [options] [wallpaper|glob...]",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "reset",
+				Usage: "Remove all existing tags first, so wallpapers only keep the tags the rules give them.",
+			},
+			&cli.BoolFlag{
+				Name:    "dry-run",
+				Aliases: []string{"n"},
+				Usage:   "Show the changes without making them.",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArgs{
+				Name:      "wallpapers",
+				UsageText: "IDs of wallpapers or glob patterns matching IDs (e.g. 'forest-*'). Defaults to all wallpapers.",
+				Min:       0,
+				Max:       -1,
+			},
+		},
+		Action: retagAction,
+	}
+}
+
+func retagAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+	if len(w.Config.Import.Rules) == 0 {
+		return fmt.Errorf("no import rules configured")
+	}
+
+	wps := w.Store.Wallpapers
+	if patterns := cmd.StringArgs("wallpapers"); len(patterns) > 0 {
+		var err error
+		wps, err = w.MatchWallpapers(patterns)
+		if err != nil {
+			return err
+		}
+	}
+
+	dryRun := cmd.Bool("dry-run")
+	changed := 0
+	for _, wp := range wps {
+		tags := make(map[string]string)
+		if !cmd.Bool("reset") {
+			maps.Copy(tags, wp.Tags)
+		}
+		maps.Copy(tags, w.RuleTags(wp))
+		if maps.Equal(tags, wp.Tags) {
+			continue
+		}
+
+		changed++
+		var changes []string
+		for _, key := range slices.Sorted(maps.Keys(wp.Tags)) {
+			if value, ok := tags[key]; !ok || value != wp.Tags[key] {
+				changes = append(changes, "-"+formatTag(key, wp.Tags[key]))
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(tags)) {
+			if value, ok := wp.Tags[key]; !ok || value != tags[key] {
+				changes = append(changes, "+"+formatTag(key, tags[key]))
+			}
+		}
+		fmt.Printf("%s: %s\n", wp.Id, strings.Join(changes, " "))
+
+		if !dryRun {
+			if len(tags) == 0 {
+				tags = nil
+			}
+			wp.Tags = tags
+		}
+	}
+
+	if dryRun {
+		logger.Infof("would retag %d wallpapers", changed)
+		return nil
+	}
+	if changed > 0 {
+		w.Sync(ctx)
+	}
+	logger.Infof("retagged %d wallpapers", changed)
+	return nil
+}
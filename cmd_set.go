@@ -14,7 +14,23 @@ func setCommand() *cli.Command {
 		Usage:        "Set the wallpaper",
 		HideHelp:     true,
 		OnUsageError: forwardUsageError,
-		Flags:        []cli.Flag{},
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "strategy",
+				Aliases: []string{"s"},
+				Usage:   "How to pick a wallpaper when none is given, overriding behavior.strategy: " + strategyNames() + ".",
+			},
+			&cli.StringSliceFlag{
+				Name:    "tag",
+				Aliases: []string{"t"},
+				Usage:   "When picking a random wallpaper, only pick wallpapers with this tag (key or key=value). Can be repeated.",
+			},
+			&cli.StringFlag{
+				Name:    "filter",
+				Aliases: []string{"f"},
+				Usage:   "When picking a random wallpaper, only pick wallpapers matching a filter expression, e.g. 'tag:nature and width>=2560 and not disabled'.",
+			},
+		},
 		Arguments: []cli.Argument{
 			&cli.StringArg{
 				Name: "wallpaper",
@@ -30,7 +46,14 @@ func setAction(ctx context.Context, cmd *cli.Command) error {
 
 	wallpaperId := cmd.StringArg("wallpaper")
 	if wallpaperId == "" {
-		wp := w.RandomWallpaper(ctx)
+		match, err := wallpaperMatcher(cmd)
+		if err != nil {
+			return err
+		}
+		wp, err := w.PickWallpaper(ctx, cmd.String("strategy"), match)
+		if err != nil {
+			return fmt.Errorf("picking wallpaper: %w", err)
+		}
 		if wp == nil {
 			return fmt.Errorf("no wallpapers enabled/found")
 		}
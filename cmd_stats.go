@@ -0,0 +1,150 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func statsCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "stats",
+		Usage:        "Show how often and how long wallpapers were shown",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "limit",
+				Aliases: []string{"n"},
+				Usage:   "Number of most and least shown wallpapers to show (0 for all).",
+				Value:   10,
+			},
+			&cli.BoolFlag{
+				Name:    "json",
+				Aliases: []string{"j"},
+				Usage:   "Output statistics for all wallpapers in JSON format.",
+			},
+			&cli.StringSliceFlag{
+				Name:    "tag",
+				Aliases: []string{"t"},
+				Usage:   "Only include wallpapers with this tag (key or key=value). Can be repeated.",
+			},
+			&cli.StringFlag{
+				Name:    "filter",
+				Aliases: []string{"f"},
+				Usage:   "Only include wallpapers matching a filter expression, e.g. 'tag:nature and width>=2560 and not disabled'.",
+			},
+		},
+		Action: statsAction,
+	}
+}
+
+type wallpaperStatsEntry struct {
+	Id string `json:"id"`
+	*WallpaperStats
+}
+
+type tagStats struct {
+	// Number of wallpapers with the tag
+	Wallpapers   int   `json:"wallpapers"`
+	Count        int   `json:"count"`
+	ShownSeconds int64 `json:"shown_seconds"`
+}
+
+type statsReport struct {
+	// Shown wallpapers, most shown first
+	Shown      []wallpaperStatsEntry `json:"shown"`
+	NeverShown []string              `json:"never_shown"`
+	Tags       map[string]*tagStats  `json:"tags"`
+}
+
+func statsAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+
+	match, err := wallpaperMatcher(cmd)
+	if err != nil {
+		return err
+	}
+	stats, err := w.LoadStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	report := statsReport{
+		Shown:      []wallpaperStatsEntry{},
+		NeverShown: []string{},
+		Tags:       make(map[string]*tagStats),
+	}
+	for _, wp := range filterWallpapers(w.Store.Wallpapers, match) {
+		s, ok := stats.Wallpapers[wp.Id]
+		if !ok || s.Count == 0 {
+			report.NeverShown = append(report.NeverShown, wp.Id)
+			s = &WallpaperStats{}
+		} else {
+			report.Shown = append(report.Shown, wallpaperStatsEntry{wp.Id, s})
+		}
+
+		for key, value := range wp.Tags {
+			tag := formatTag(key, value)
+			t, ok := report.Tags[tag]
+			if !ok {
+				t = &tagStats{}
+				report.Tags[tag] = t
+			}
+			t.Wallpapers++
+			t.Count += s.Count
+			t.ShownSeconds += s.ShownSeconds
+		}
+	}
+	slices.SortStableFunc(report.Shown, func(a, b wallpaperStatsEntry) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ShownSeconds, a.ShownSeconds)
+	})
+
+	if cmd.Bool("json") {
+		return printJSON(report)
+	}
+
+	limit := cmd.Int("limit")
+	if limit <= 0 || limit > len(report.Shown) {
+		limit = len(report.Shown)
+	}
+	printEntry := func(e wallpaperStatsEntry) {
+		fmt.Printf("  %s: %d times, %s on screen, last %s\n",
+			e.Id, e.Count, formatSeconds(e.ShownSeconds), e.LastSet.Local().Format(time.DateTime))
+	}
+
+	fmt.Printf("Most shown:\n")
+	for _, e := range report.Shown[:limit] {
+		printEntry(e)
+	}
+	fmt.Printf("\nLeast shown:\n")
+	for i := len(report.Shown) - 1; i >= len(report.Shown)-limit; i-- {
+		printEntry(report.Shown[i])
+	}
+	fmt.Printf("\nNever shown (%d):\n", len(report.NeverShown))
+	for _, id := range report.NeverShown {
+		fmt.Printf("  %s\n", id)
+	}
+	if len(report.Tags) > 0 {
+		fmt.Printf("\nTags:\n")
+		for _, tag := range slices.Sorted(maps.Keys(report.Tags)) {
+			t := report.Tags[tag]
+			fmt.Printf("  %s: %d wallpapers, %d times, %s on screen\n",
+				tag, t.Wallpapers, t.Count, formatSeconds(t.ShownSeconds))
+		}
+	}
+
+	return nil
+}
+
+func formatSeconds(seconds int64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
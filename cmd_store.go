@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+)
+
+func storeCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "store",
+		Usage:        "Manage the wallpaper store",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Commands: []*cli.Command{
+			{
+				Name:         "migrate",
+				Usage:        "Upgrade the store to the current store version",
+				HideHelp:     true,
+				OnUsageError: forwardUsageError,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Aliases: []string{"n"},
+						Usage:   "Show what would change without writing the store.",
+					},
+				},
+				Action: storeMigrateAction,
+			},
+		},
+	}
+}
+
+func storeMigrateAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+	dryRun := cmd.Bool("dry-run")
+
+	if w.Store.Version == storeVersion {
+		logger.Infof("store is already at version %d", storeVersion)
+		return nil
+	}
+
+	steps, err := w.MigrateStore(ctx, dryRun)
+	for _, step := range steps {
+		fmt.Printf("version %d -> %d: %s\n", step.From, step.To, step.Description)
+		for _, change := range step.Changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		logger.Infof("dry run, store not modified")
+	} else {
+		logger.Infof("store migrated to version %d", storeVersion)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/urfave/cli/v3"
+)
+
+func tagCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "tag",
+		Aliases:      []string{"t"},
+		Usage:        "Manage wallpaper tags",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Commands: []*cli.Command{
+			{
+				Name:         "add",
+				Usage:        "Add tags to wallpapers",
+				UsageText:    "walls tag add --tag <key[=value]>... <wallpaper>...",
+				HideHelp:     true,
+				OnUsageError: forwardUsageError,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:    "tag",
+						Aliases: []string{"t"},
+						Usage:   "Tag to add (key or key=value). Can be repeated.",
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArgs{
+						Name: "wallpapers",
+						Min:  0,
+						Max:  -1,
+					},
+				},
+				Action: tagAddAction,
+			},
+			{
+				Name:         "rm",
+				Aliases:      []string{"remove", "delete"},
+				Usage:        "Remove tags from wallpapers (key=value only removes the tag if it has that value)",
+				UsageText:    "walls tag rm --tag <key[=value]>... <wallpaper>...",
+				HideHelp:     true,
+				OnUsageError: forwardUsageError,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:    "tag",
+						Aliases: []string{"t"},
+						Usage:   "Tag to remove (key, or key=value to only remove it if it has that value). Can be repeated.",
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArgs{
+						Name: "wallpapers",
+						Min:  0,
+						Max:  -1,
+					},
+				},
+				Action: tagRmAction,
+			},
+			{
+				Name:         "ls",
+				Aliases:      []string{"list"},
+				Usage:        "List the tags of wallpapers, or all tags in use if no wallpapers are given",
+				UsageText:    "walls tag ls [wallpaper...]",
+				HideHelp:     true,
+				OnUsageError: forwardUsageError,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "json",
+						Aliases: []string{"j"},
+						Usage:   "Output tags in JSON format.",
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArgs{
+						Name: "wallpapers",
+						Min:  0,
+						Max:  -1,
+					},
+				},
+				Action: tagLsAction,
+			},
+		},
+	}
+}
+
+// tagTargets returns the wallpapers and tags given to tag add or tag rm. Tags
+// are only taken from --tag, so a tag named like a wallpaper can't be
+// mistaken for one.
+func tagTargets(w *Walls, cmd *cli.Command) ([]*Wallpaper, []string, error) {
+	ids := cmd.StringArgs("wallpapers")
+	tags := cmd.StringSlice("tag")
+	if len(ids) == 0 {
+		return nil, nil, fmt.Errorf("at least one wallpaper is required\nusage: %s", cmd.UsageText)
+	}
+	if len(tags) == 0 {
+		return nil, nil, fmt.Errorf("at least one --tag is required\nusage: %s", cmd.UsageText)
+	}
+	for _, tag := range tags {
+		if _, _, _, err := parseTag(tag); err != nil {
+			return nil, nil, err
+		}
+	}
+	wps := make([]*Wallpaper, len(ids))
+	for i, id := range ids {
+		if wps[i] = w.FindWallpaper(id); wps[i] == nil {
+			return nil, nil, fmt.Errorf("wallpaper with id %s not found", id)
+		}
+	}
+	return wps, tags, nil
+}
+
+func tagAddAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+	wps, tags, err := tagTargets(w, cmd)
+	if err != nil {
+		return err
+	}
+	defer w.Sync(ctx)
+
+	for _, wp := range wps {
+		if wp.Tags == nil {
+			wp.Tags = make(map[string]string)
+		}
+		for _, tag := range tags {
+			key, value, _, _ := parseTag(tag)
+			wp.Tags[key] = value
+		}
+	}
+
+	logger.Infof("tagged %d wallpapers", len(wps))
+	return nil
+}
+
+func tagRmAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+	wps, tags, err := tagTargets(w, cmd)
+	if err != nil {
+		return err
+	}
+	defer w.Sync(ctx)
+
+	removed := 0
+	for _, wp := range wps {
+		for _, tag := range tags {
+			key, value, hasValue, _ := parseTag(tag)
+			if wp.HasTag(key, value, hasValue) {
+				delete(wp.Tags, key)
+				removed++
+			}
+		}
+	}
+
+	logger.Infof("removed %d tags from %d wallpapers", removed, len(wps))
+	return nil
+}
+
+func tagLsAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+	ids := cmd.StringArgs("wallpapers")
+
+	if len(ids) > 0 {
+		wps := make([]*Wallpaper, len(ids))
+		for i, id := range ids {
+			wps[i] = w.FindWallpaper(id)
+			if wps[i] == nil {
+				return fmt.Errorf("wallpaper with id %s not found", id)
+			}
+		}
+
+		if cmd.Bool("json") {
+			out := make(map[string]map[string]string, len(wps))
+			for _, wp := range wps {
+				out[wp.Id] = wp.Tags
+				if out[wp.Id] == nil {
+					out[wp.Id] = map[string]string{}
+				}
+			}
+			return printJSON(out)
+		}
+
+		for _, wp := range wps {
+			fmt.Printf("%s: %s\n", wp.Id, formatTags(wp.Tags))
+		}
+		return nil
+	}
+
+	// count the wallpapers using each tag
+	counts := make(map[string]int)
+	for _, wp := range w.Store.Wallpapers {
+		for key, value := range wp.Tags {
+			tag := formatTag(key, value)
+			counts[tag]++
+		}
+	}
+
+	if cmd.Bool("json") {
+		return printJSON(counts)
+	}
+
+	for _, tag := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Printf("%s (%d)\n", tag, counts[tag])
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// runTag runs walls tag with the given arguments against a store holding the
+// wallpapers forest and night.
+func runTag(t *testing.T, args ...string) (*Walls, error) {
+	t.Helper()
+	w := &Walls{
+		Config: &Config{Storage: StorageConfig{Sources: t.TempDir()}},
+		Store: &Store{Wallpapers: []*Wallpaper{
+			{Id: "forest", Tags: map[string]string{"night": ""}},
+			{Id: "night", Tags: map[string]string{"forest": ""}},
+		}},
+	}
+	ctx := setWalls(context.Background(), w)
+	err := tagCommand().Run(ctx, append([]string{"tag"}, args...))
+	return w, err
+}
+
+func TestTagAddNamedLikeId(t *testing.T) {
+	w, err := runTag(t, "add", "--tag", "forest", "-t", "mood=dark", "night")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forest, night := w.FindWallpaper("forest"), w.FindWallpaper("night")
+	if _, ok := forest.Tags["forest"]; ok {
+		t.Errorf("forest was tagged: %v", forest.Tags)
+	}
+	if _, ok := night.Tags["forest"]; !ok || night.Tags["mood"] != "dark" {
+		t.Errorf("night tags = %v, want forest and mood=dark", night.Tags)
+	}
+}
+
+func TestTagRmNamedLikeId(t *testing.T) {
+	w, err := runTag(t, "rm", "--tag", "night", "forest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forest, night := w.FindWallpaper("forest"), w.FindWallpaper("night")
+	if _, ok := forest.Tags["night"]; ok {
+		t.Errorf("forest still has tag night: %v", forest.Tags)
+	}
+	if _, ok := night.Tags["forest"]; !ok {
+		t.Errorf("night lost tag forest: %v", night.Tags)
+	}
+}
+
+func TestTagArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no tags", []string{"add", "forest", "night"}},
+		{"no wallpapers", []string{"add", "--tag", "forest"}},
+		{"unknown wallpaper", []string{"rm", "--tag", "forest", "desert"}},
+		{"empty key", []string{"add", "--tag", "=dark", "night"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := runTag(t, tt.args...)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if len(w.FindWallpaper("night").Tags) != 1 || len(w.FindWallpaper("forest").Tags) != 1 {
+				t.Errorf("tags changed despite the error")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli/v3"
+)
+
+func watchCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "watch",
+		Usage:        "Watch directories and add new images to the store",
+		UsageText:    "walls watch [options] [directory...]",
+		Description:  "Without directories, the folders configured in the watch section of the config are watched.",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "precache",
+				Usage: "Precache effects for new wallpapers.",
+			},
+			&cli.BoolFlag{
+				Name:    "recursive",
+				Aliases: []string{"r"},
+				Usage:   "Also watch subdirectories of the given directories.",
+			},
+			&cli.StringFlag{
+				Name:  "mode",
+				Usage: "How to import files: copy, hardlink, symlink or reference. Defaults to import.mode from the config.",
+			},
+			&cli.StringSliceFlag{
+				Name:    "tag",
+				Aliases: []string{"t"},
+				Usage:   "Tag new wallpapers with this tag (key or key=value). Can be repeated.",
+			},
+			&cli.DurationFlag{
+				Name:  "settle",
+				Usage: "How long a new file must stay unchanged before it is added. Defaults to watch.settle from the config, or 2s.",
+			},
+		},
+		Arguments: []cli.Argument{
+			&cli.StringArgs{
+				Name: "directories",
+				Min:  0,
+				Max:  -1,
+			},
+		},
+		Action: watchAction,
+	}
+}
+
+func watchAction(ctx context.Context, cmd *cli.Command) error {
+	w := getWalls(ctx)
+
+	tags := make(map[string]string)
+	for _, tag := range cmd.StringSlice("tag") {
+		key, value, _, err := parseTag(tag)
+		if err != nil {
+			return err
+		}
+		tags[key] = value
+	}
+	mode := cmd.String("mode")
+	if mode != "" && !slices.Contains(importModes, mode) {
+		return fmt.Errorf("--mode: unknown import mode %s (available: %s)", mode, strings.Join(importModes, ", "))
+	}
+
+	var folders []*WatchFolder
+	if dirs := cmd.StringArgs("directories"); len(dirs) > 0 {
+		for _, dir := range dirs {
+			folders = append(folders, &WatchFolder{
+				Path:      dir,
+				Recursive: cmd.Bool("recursive"),
+				Precache:  cmd.Bool("precache"),
+				Mode:      mode,
+				Tags:      tags,
+			})
+		}
+	} else {
+		for i := range w.Config.Watch.Folders {
+			// flags add to the configured options
+			folder := w.Config.Watch.Folders[i]
+			folder.Recursive = folder.Recursive || cmd.Bool("recursive")
+			folder.Precache = folder.Precache || cmd.Bool("precache")
+			if mode != "" {
+				folder.Mode = mode
+			}
+			folder.Tags = maps.Clone(folder.Tags)
+			if folder.Tags == nil {
+				folder.Tags = make(map[string]string)
+			}
+			maps.Copy(folder.Tags, tags)
+			folders = append(folders, &folder)
+		}
+	}
+	if len(folders) == 0 {
+		return fmt.Errorf("no directories to watch\nusage: %s", cmd.UsageText)
+	}
+
+	watcher, err := NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	for _, folder := range folders {
+		if stat, err := os.Stat(folder.Path); err != nil {
+			return err
+		} else if !stat.IsDir() {
+			return fmt.Errorf("%s is not a directory", folder.Path)
+		}
+		if err := watcher.Add(folder); err != nil {
+			return err
+		}
+		logger.Infof("watching %s", folder.Path)
+	}
+
+	settle := cmd.Duration("settle")
+	if !cmd.IsSet("settle") {
+		settle = w.Config.Watch.SettleDelay()
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return watcher.Watch(ctx, settle, func(folder *WatchFolder, paths []string) {
+		result, err := w.ImportWatched(ctx, folder, paths)
+		if err != nil {
+			logger.Errorf("importing from %s: %w", folder.Path, err)
+		}
+		if result == nil {
+			return
+		}
+		for _, issue := range result.Skipped {
+			var invalid *ValidationError
+			if errors.As(issue.Reason, &invalid) {
+				logger.Warnf("skipped %s: %s", issue.Path, issue.Reason)
+			} else {
+				logger.Debugf("skipped %s: %s", issue.Path, issue.Reason)
+			}
+		}
+		for _, issue := range result.Failed {
+			logger.Errorf("adding %s: %w", issue.Path, issue.Reason)
+		}
+		for _, wp := range result.Added {
+			logger.Infof("wallpaper %s added", wp.Id)
+		}
+		if len(result.Added) > 0 && folder.Precache {
+			if err := w.PrecacheWallpapers(ctx, result.Added, false); err != nil {
+				logger.Errorf("precaching wallpapers: %w", err)
+			}
+		} else {
+			w.ThumbnailWallpapers(ctx, result.Added, false)
+		}
+	})
+}
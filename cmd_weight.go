@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/urfave/cli/v3"
+)
+
+func weightCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "weight",
+		Usage:        "Set how likely wallpapers are to be picked by the weighted strategy",
+		UsageText:    "walls weight <weight> <wallpaper|glob>...",
+		HideHelp:     true,
+		OnUsageError: forwardUsageError,
+		Arguments: []cli.Argument{
+			&cli.StringArgs{
+				Name: "args",
+				Min:  0,
+				Max:  -1,
+			},
+		},
+		Action: weightAction,
+	}
+}
+
+func weightAction(ctx context.Context, cmd *cli.Command) error {
+	args := cmd.StringArgs("args")
+	if len(args) < 2 {
+		return fmt.Errorf("a weight and at least one wallpaper are required\nusage: %s", cmd.UsageText)
+	}
+	weight, err := strconv.Atoi(args[0])
+	if err != nil || weight < 1 {
+		return fmt.Errorf("invalid weight %s: must be a positive integer", args[0])
+	}
+
+	w := getWalls(ctx)
+	wps, err := w.MatchWallpapers(args[1:])
+	if err != nil {
+		return err
+	}
+	defer w.Sync(ctx)
+
+	for _, wp := range wps {
+		wp.Weight = weight
+	}
+
+	logger.Infof("set weight of %d wallpapers to %d", len(wps), weight)
+	return nil
+}
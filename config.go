@@ -6,7 +6,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/calico32/kdl-go"
 )
@@ -14,6 +16,8 @@ import (
 type Config struct {
 	Storage  StorageConfig  `kdl:"storage"`
 	Effects  EffectsConfig  `kdl:"effects"`
+	Import   ImportConfig   `kdl:"import"`
+	Watch    WatchConfig    `kdl:"watch"`
 	Behavior BehaviorConfig `kdl:"behavior"`
 }
 
@@ -24,13 +28,41 @@ type StorageConfig struct {
 }
 
 type EffectsConfig struct {
-	Default string              `kdl:"default"`
-	Effects map[string][]string `kdl:",children"`
+	Default string            `kdl:"default"`
+	Effects map[string]Effect `kdl:",children"`
+}
+
+type ImportConfig struct {
+	Mode        string `kdl:"mode"`
+	Orientation string `kdl:"orientation"`
+	OnConflict  string `kdl:"on-conflict"`
+	// Larger images are scaled down to fit, zero for no limit
+	MaxResolution Resolution     `kdl:"max-resolution"`
+	Validate      ValidateConfig `kdl:"validate"`
+	Rules         []ImportRule   `kdl:"rule,multiple"`
+}
+
+type WatchConfig struct {
+	Settle  string        `kdl:"settle"`
+	Folders []WatchFolder `kdl:"folder,multiple"`
+}
+
+// SettleDelay returns how long a new file must stay unchanged before `walls
+// watch` imports it.
+func (c *WatchConfig) SettleDelay() time.Duration {
+	d, err := time.ParseDuration(c.Settle)
+	if err != nil || c.Settle == "" {
+		return defaultSettleDelay
+	}
+	return d
 }
 
 type BehaviorConfig struct {
-	AllowRepeat bool  `kdl:"allow-repeat"`
-	Set         []Set `kdl:"set,multiple"`
+	AllowRepeat bool    `kdl:"allow-repeat"`
+	HistorySize int     `kdl:"history-size"`
+	Strategy    string  `kdl:"strategy"`
+	RatingBias  float64 `kdl:"rating-bias"`
+	Set         []Set   `kdl:"set,multiple"`
 }
 
 type Set struct {
@@ -71,10 +103,17 @@ func DefaultConfig() *Config {
 			Runtime: runtimeDir,
 		},
 		Effects: EffectsConfig{
-			Effects: make(map[string][]string),
+			Effects: make(map[string]Effect),
+		},
+		Import: ImportConfig{
+			Mode:        ImportCopy,
+			Orientation: OrientationRecord,
+			OnConflict:  ConflictError,
 		},
 		Behavior: BehaviorConfig{
 			AllowRepeat: false,
+			HistorySize: defaultHistorySize,
+			Strategy:    "random",
 			Set:         []Set{},
 		},
 	}
@@ -110,6 +149,68 @@ func parseConfig(r io.Reader) (*Config, error) {
 	if config.Storage.Runtime == "" {
 		config.Storage.Runtime = defaultConfig.Storage.Runtime
 	}
+	if config.Import.Mode == "" {
+		config.Import.Mode = defaultConfig.Import.Mode
+	}
+	if !slices.Contains(importModes, config.Import.Mode) {
+		return nil, fmt.Errorf("import.mode: unknown import mode %s (available: %s)", config.Import.Mode, strings.Join(importModes, ", "))
+	}
+	if config.Import.Orientation == "" {
+		config.Import.Orientation = defaultConfig.Import.Orientation
+	}
+	if config.Import.Orientation != OrientationRecord && config.Import.Orientation != OrientationRotate {
+		return nil, fmt.Errorf("import.orientation: must be %s or %s", OrientationRecord, OrientationRotate)
+	}
+	if config.Import.OnConflict == "" {
+		config.Import.OnConflict = defaultConfig.Import.OnConflict
+	}
+	if !slices.Contains(conflictPolicies, config.Import.OnConflict) {
+		return nil, fmt.Errorf("import.on-conflict: unknown policy %s (available: %s)", config.Import.OnConflict, strings.Join(conflictPolicies, ", "))
+	}
+	if config.Import.MaxResolution.Width < 0 || config.Import.MaxResolution.Height < 0 {
+		return nil, fmt.Errorf("import.max-resolution: must not be negative")
+	}
+	if err := config.Import.Validate.compile(); err != nil {
+		return nil, fmt.Errorf("import.validate: %w", err)
+	}
+	for i := range config.Import.Rules {
+		if err := config.Import.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("import.rule %d: %w", i+1, err)
+		}
+	}
+	if config.Watch.Settle != "" {
+		if d, err := time.ParseDuration(config.Watch.Settle); err != nil || d < 0 {
+			return nil, fmt.Errorf("watch.settle: invalid duration %q", config.Watch.Settle)
+		}
+	}
+	for i := range config.Watch.Folders {
+		folder := &config.Watch.Folders[i]
+		if folder.Path == "" {
+			return nil, fmt.Errorf("watch.folder: path is required")
+		}
+		folder.Path = expandPath(folder.Path)
+		if folder.Mode != "" && !slices.Contains(importModes, folder.Mode) {
+			return nil, fmt.Errorf("watch.folder %s: unknown import mode %s (available: %s)", folder.Path, folder.Mode, strings.Join(importModes, ", "))
+		}
+	}
+	if config.Behavior.Strategy == "" {
+		config.Behavior.Strategy = defaultConfig.Behavior.Strategy
+	}
+	if config.Behavior.RatingBias < 0 {
+		return nil, fmt.Errorf("behavior.rating-bias: must not be negative")
+	}
+	if _, ok := strategies[config.Behavior.Strategy]; !ok {
+		return nil, fmt.Errorf("behavior.strategy: unknown strategy %s (available: %s)", config.Behavior.Strategy, strategyNames())
+	}
+	for name, effect := range config.Effects.Effects {
+		if slices.Contains(reservedCacheDirs, name) {
+			return nil, fmt.Errorf("effects: effect name %s is reserved", name)
+		}
+		if err := effect.prepare(); err != nil {
+			return nil, fmt.Errorf("effects.%s: %w", name, err)
+		}
+		config.Effects.Effects[name] = effect
+	}
 	if config.Effects.Default != "" {
 		if _, ok := config.Effects.Effects[config.Effects.Default]; !ok {
 			return nil, fmt.Errorf("effects.default: unknown effect %s", config.Effects.Default)
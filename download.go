@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Downloader fetches files over HTTP(S) into a directory of partial downloads.
+// An interrupted download is resumed the next time the same URL is
+// downloaded, if the server supports range requests.
+type Downloader struct {
+	// HTTP client to use, http.DefaultClient if nil
+	Client *http.Client
+	// Directory for partial and completed downloads
+	Dir string
+	// Maximum size of a download in bytes, 0 for no limit
+	MaxSize int64
+	// Maximum time a download may take, 0 for no limit
+	Timeout time.Duration
+}
+
+type Download struct {
+	URL string
+	// Path of the downloaded file; remove it once it is no longer needed
+	Path string
+	// Filename suggested by the server (Content-Disposition) or the URL path
+	Filename string
+}
+
+// Cache subdirectory holding partial downloads
+const downloadsCacheDir = "downloads"
+
+// Cache subdirectories that aren't effects
+var reservedCacheDirs = []string{downloadsCacheDir, thumbsCacheDir}
+
+// ErrTooLarge is returned when a download exceeds Downloader.MaxSize or an
+// image read from stdin exceeds ImportOptions.StdinMaxSize.
+var ErrTooLarge = errors.New("file exceeds maximum size")
+
+// isURL reports whether s should be downloaded rather than read from disk.
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// Download downloads rawURL, resuming a previous partial download if there is
+// one.
+func (d *Downloader) Download(ctx context.Context, rawURL string) (*Download, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing url: %w", err)
+	}
+
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating download directory: %w", err)
+	}
+	partPath := d.partPath(rawURL)
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	resp, offset, err := d.request(ctx, rawURL, partPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if d.MaxSize > 0 && resp.ContentLength > 0 && offset+resp.ContentLength > d.MaxSize {
+		removePartial(partPath)
+		return nil, fmt.Errorf("%w (%d bytes, limit %d)", ErrTooLarge, offset+resp.ContentLength, d.MaxSize)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		logger.Debugf("resuming download of %s at byte %d", rawURL, offset)
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening download file: %w", err)
+	}
+
+	var body io.Reader = resp.Body
+	if d.MaxSize > 0 {
+		// read one byte past the limit to detect oversized downloads
+		body = io.LimitReader(resp.Body, d.MaxSize-offset+1)
+	}
+	n, err := io.Copy(f, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// keep the partial download so it can be resumed
+		return nil, fmt.Errorf("downloading %s (run again to resume): %w", rawURL, err)
+	}
+	if d.MaxSize > 0 && offset+n > d.MaxSize {
+		removePartial(partPath)
+		return nil, fmt.Errorf("%w (limit %d bytes)", ErrTooLarge, d.MaxSize)
+	}
+	// the download is complete, there is nothing left to resume
+	os.Remove(validatorPath(partPath))
+
+	logger.Debugf("downloaded %s to %s (%d bytes)", rawURL, partPath, offset+n)
+	return &Download{
+		URL:      rawURL,
+		Path:     partPath,
+		Filename: downloadFilename(u, resp.Header.Get("Content-Disposition")),
+	}, nil
+}
+
+// partPath returns the path rawURL is downloaded to.
+func (d *Downloader) partPath(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(d.Dir, hex.EncodeToString(sum[:8])+".part")
+}
+
+// validatorPath returns the path holding the ETag or Last-Modified date of
+// the response a partial download started with, to check that the resource
+// hasn't changed when resuming it.
+func validatorPath(partPath string) string {
+	return partPath + ".validator"
+}
+
+// removePartial removes a partial download and its validator.
+func removePartial(partPath string) error {
+	if err := os.Remove(validatorPath(partPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(partPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// request starts the download, asking for the rest of the file if partPath
+// holds a partial download of the same version of the resource. It returns
+// the response and the offset its body starts at.
+func (d *Downloader) request(ctx context.Context, rawURL string, partPath string) (*http.Response, int64, error) {
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var offset int64
+	var validator string
+	if stat, err := os.Stat(partPath); err == nil && stat.Size() > 0 {
+		// without a validator, the server can't tell whether the partial
+		// download is stale, so it is only resumed with one
+		if data, err := os.ReadFile(validatorPath(partPath)); err == nil && len(data) > 0 {
+			offset, validator = stat.Size(), string(data)
+		} else {
+			logger.Debugf("cannot resume download of %s without an ETag or Last-Modified date, restarting", rawURL)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// the server sends the whole resource if it changed
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("downloading %s: %w", rawURL, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); ok && start == offset {
+			return resp, offset, nil
+		}
+		resp.Body.Close()
+		logger.Debugf("server sent a different range of %s than requested, restarting download", rawURL)
+		return d.restart(ctx, rawURL, partPath)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the partial download is stale or already complete; start over
+		resp.Body.Close()
+		logger.Debugf("server rejected resuming %s, restarting download", rawURL)
+		return d.restart(ctx, rawURL, partPath)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// the server ignored the range (or there was none), so start over
+		if err := os.WriteFile(validatorPath(partPath), []byte(responseValidator(resp.Header)), 0644); err != nil {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("writing download validator: %w", err)
+		}
+		return resp, 0, nil
+	default:
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("downloading %s: %s", rawURL, resp.Status)
+	}
+}
+
+// restart removes the partial download and requests the whole resource.
+func (d *Downloader) restart(ctx context.Context, rawURL string, partPath string) (*http.Response, int64, error) {
+	if err := removePartial(partPath); err != nil {
+		return nil, 0, fmt.Errorf("removing partial download: %w", err)
+	}
+	return d.request(ctx, rawURL, partPath)
+}
+
+// responseValidator returns the value for If-Range identifying the version of
+// the resource in the response, or "" if there is none. Weak ETags can't be
+// used with ranges.
+func responseValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// contentRangeStart returns the position of the first byte in a Content-Range
+// header such as "bytes 100-199/200".
+func contentRangeStart(contentRange string) (int64, bool) {
+	spec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// downloadFilename picks a filename for a download, preferring the server's
+// Content-Disposition over the last element of the URL path.
+func downloadFilename(u *url.URL, contentDisposition string) string {
+	if contentDisposition != "" {
+		if _, params, err := mime.ParseMediaType(contentDisposition); err == nil {
+			if name := filepath.Base(params["filename"]); params["filename"] != "" && name != "." && name != "/" {
+				return name
+			}
+		}
+	}
+	if name := path.Base(u.Path); name != "." && name != "/" && name != "" {
+		return name
+	}
+	if u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "download"
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testContent = bytes.Repeat([]byte("0123456789abcdef"), 1024)
+
+// serveContent serves content with range support, recording the Range header
+// of each request.
+func serveContent(t *testing.T, etag string, content []byte) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	return server, &ranges
+}
+
+// writePartial sets up a partial download of rawURL holding data, started with
+// the given validator.
+func writePartial(t *testing.T, d *Downloader, rawURL string, data []byte, validator string) {
+	t.Helper()
+	partPath := d.partPath(rawURL)
+	if err := os.WriteFile(partPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(validatorPath(partPath), []byte(validator), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkDownload(t *testing.T, dl *Download, err error, want []byte) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	got, err := os.ReadFile(dl.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("downloaded %d bytes, want %d bytes of the original", len(got), len(want))
+	}
+	if _, err := os.Stat(validatorPath(dl.Path)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("validator of completed download not removed: %v", err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	server, ranges := serveContent(t, `"v1"`, testContent)
+	d := &Downloader{Dir: t.TempDir()}
+
+	dl, err := d.Download(context.Background(), server.URL+"/image.jpg")
+	checkDownload(t, dl, err, testContent)
+	if len(*ranges) != 1 || (*ranges)[0] != "" {
+		t.Errorf("range headers = %q, want a single request without range", *ranges)
+	}
+	if dl.Filename != "image.jpg" {
+		t.Errorf("filename = %q, want image.jpg", dl.Filename)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	server, ranges := serveContent(t, `"v1"`, testContent)
+	d := &Downloader{Dir: t.TempDir()}
+	rawURL := server.URL + "/image.jpg"
+	writePartial(t, d, rawURL, testContent[:1000], `"v1"`)
+
+	dl, err := d.Download(context.Background(), rawURL)
+	checkDownload(t, dl, err, testContent)
+	if len(*ranges) != 1 || (*ranges)[0] != "bytes=1000-" {
+		t.Errorf("range headers = %q, want a single request for bytes=1000-", *ranges)
+	}
+}
+
+func TestDownloadResumeChanged(t *testing.T) {
+	changed := bytes.ToUpper(testContent)
+	server, _ := serveContent(t, `"v2"`, changed)
+	d := &Downloader{Dir: t.TempDir()}
+	rawURL := server.URL + "/image.jpg"
+	// started with an older version of the resource
+	writePartial(t, d, rawURL, testContent[:1000], `"v1"`)
+
+	dl, err := d.Download(context.Background(), rawURL)
+	checkDownload(t, dl, err, changed)
+}
+
+func TestDownloadResumeWithoutValidator(t *testing.T) {
+	server, ranges := serveContent(t, "", testContent)
+	d := &Downloader{Dir: t.TempDir()}
+	rawURL := server.URL + "/image.jpg"
+	writePartial(t, d, rawURL, testContent[:1000], "")
+
+	dl, err := d.Download(context.Background(), rawURL)
+	checkDownload(t, dl, err, testContent)
+	if len(*ranges) != 1 || (*ranges)[0] != "" {
+		t.Errorf("range headers = %q, want a single request without range", *ranges)
+	}
+}
+
+func TestDownloadResumeWrongRange(t *testing.T) {
+	// a server that answers every range request with the start of the file
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-99/%d", len(testContent)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(testContent[:100])
+			return
+		}
+		w.Write(testContent)
+	}))
+	defer server.Close()
+	d := &Downloader{Dir: t.TempDir()}
+	rawURL := server.URL + "/image.jpg"
+	writePartial(t, d, rawURL, testContent[:1000], `"v1"`)
+
+	dl, err := d.Download(context.Background(), rawURL)
+	checkDownload(t, dl, err, testContent)
+}
+
+func TestDownloadRangeNotSatisfiable(t *testing.T) {
+	server, ranges := serveContent(t, `"v1"`, testContent)
+	d := &Downloader{Dir: t.TempDir()}
+	rawURL := server.URL + "/image.jpg"
+	// a partial download longer than the resource
+	writePartial(t, d, rawURL, append(testContent, testContent[:10]...), `"v1"`)
+
+	dl, err := d.Download(context.Background(), rawURL)
+	checkDownload(t, dl, err, testContent)
+	want := []string{fmt.Sprintf("bytes=%d-", len(testContent)+10), ""}
+	if strings.Join(*ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("range headers = %q, want %q", *ranges, want)
+	}
+}
+
+func TestDownloadMaxSize(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		t.Run(fmt.Sprintf("chunked=%v", chunked), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !chunked {
+					w.Header().Set("Content-Length", fmt.Sprint(len(testContent)))
+				}
+				w.Write(testContent[:100])
+				// without a length, the limit is only noticed while reading
+				w.(http.Flusher).Flush()
+				w.Write(testContent[100:])
+			}))
+			defer server.Close()
+			d := &Downloader{Dir: t.TempDir(), MaxSize: int64(len(testContent) - 1)}
+			rawURL := server.URL + "/image.jpg"
+
+			_, err := d.Download(context.Background(), rawURL)
+			if !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("Download: %v, want ErrTooLarge", err)
+			}
+			if _, err := os.Stat(d.partPath(rawURL)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("partial download not removed: %v", err)
+			}
+
+			d.MaxSize = int64(len(testContent))
+			dl, err := d.Download(context.Background(), rawURL)
+			checkDownload(t, dl, err, testContent)
+		})
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(testContent[:100])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	d := &Downloader{Dir: t.TempDir(), Timeout: 50 * time.Millisecond}
+	rawURL := server.URL + "/image.jpg"
+
+	_, err := d.Download(context.Background(), rawURL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Download: %v, want a deadline error", err)
+	}
+	// what arrived is kept for resuming
+	if data, err := os.ReadFile(d.partPath(rawURL)); err != nil || !bytes.Equal(data, testContent[:100]) {
+		t.Errorf("partial download = %d bytes (%v), want the first 100 bytes", len(data), err)
+	}
+}
+
+func TestDownloadFilename(t *testing.T) {
+	tests := []struct {
+		url                string
+		contentDisposition string
+		want               string
+	}{
+		{"https://example.com/a/forest.jpg", "", "forest.jpg"},
+		{"https://example.com/a/forest.jpg?w=100", "", "forest.jpg"},
+		{"https://example.com/download?id=1", `attachment; filename="lake.png"`, "lake.png"},
+		{"https://example.com/download", `attachment; filename="../../etc/lake.png"`, "lake.png"},
+		{"https://example.com/download", `attachment; filename*=UTF-8''caf%C3%A9.png`, "café.png"},
+		{"https://example.com/img.jpg", "inline", "img.jpg"},
+		{"https://example.com/img.jpg", "invalid; ;", "img.jpg"},
+		{"https://example.com/", "", "example.com"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := downloadFilename(u, test.contentDisposition); got != test.want {
+			t.Errorf("downloadFilename(%q, %q) = %q, want %q", test.url, test.contentDisposition, got, test.want)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="mountain.webp"`)
+		w.Write(testContent)
+	}))
+	defer server.Close()
+	d := &Downloader{Dir: t.TempDir()}
+	dl, err := d.Download(context.Background(), server.URL+"/get?id=42")
+	checkDownload(t, dl, err, testContent)
+	if dl.Filename != "mountain.webp" {
+		t.Errorf("filename = %q, want mountain.webp", dl.Filename)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"maps"
+	"math"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// Effect transforms wallpapers, either with a shell command or with one of the
+// built-in effects.
+type Effect struct {
+	// Command to run, with %i and %o replaced by the input and output paths
+	Command []string `kdl:",arguments"`
+	// Name of a built-in effect, see builtinEffects
+	Builtin string `kdl:"builtin"`
+
+	// Parameters of built-in effects; unset parameters take the effect's
+	// default
+
+	// Strength of the effect, usually in percent
+	Amount EffectParam `kdl:"amount"`
+	// Contrast change in percent for brightness, -100 to 100
+	Contrast EffectParam `kdl:"contrast"`
+	// Standard deviation of the blur in pixels, as in magick -blur 0x<sigma>
+	Sigma EffectParam `kdl:"sigma"`
+	// Color as #rrggbb for tint
+	Color string `kdl:"color"`
+	// Block size in pixels for pixelate
+	Size EffectParam `kdl:"size"`
+	// Target size for resize and crop, zero for no limit
+	Width  int `kdl:"width"`
+	Height int `kdl:"height"`
+
+	color color.RGBA
+}
+
+// EffectParam is a numeric parameter of a built-in effect, which may be set
+// to zero explicitly.
+type EffectParam struct {
+	Value float64
+	Set   bool
+}
+
+// setDefault sets the parameter to def unless it was configured.
+func (p *EffectParam) setDefault(def float64) {
+	if !p.Set {
+		*p = EffectParam{Value: def, Set: true}
+	}
+}
+
+type builtinEffect struct {
+	// Checks the parameters and fills in defaults
+	prepare func(e *Effect) error
+	apply   func(img *image.RGBA, e *Effect) *image.RGBA
+}
+
+var builtinEffects = map[string]builtinEffect{
+	"blur": {
+		prepare: func(e *Effect) error {
+			e.Sigma.setDefault(8)
+			return checkRange("sigma", e.Sigma.Value, 0, 500)
+		},
+		apply: func(img *image.RGBA, e *Effect) *image.RGBA { return gaussianBlur(img, e.Sigma.Value) },
+	},
+	"brightness": {
+		prepare: func(e *Effect) error {
+			return errors.Join(checkRange("amount", e.Amount.Value, -100, 100), checkRange("contrast", e.Contrast.Value, -100, 100))
+		},
+		apply: brightnessContrast,
+	},
+	"desaturate": {
+		prepare: func(e *Effect) error {
+			e.Amount.setDefault(100)
+			return checkRange("amount", e.Amount.Value, 0, 100)
+		},
+		apply: desaturate,
+	},
+	"tint": {
+		prepare: func(e *Effect) error {
+			e.Amount.setDefault(30)
+			c, err := parseHexColor(e.Color)
+			if err != nil {
+				return fmt.Errorf("color: %w", err)
+			}
+			e.color = c
+			return checkRange("amount", e.Amount.Value, 0, 100)
+		},
+		apply: tint,
+	},
+	"vignette": {
+		prepare: func(e *Effect) error {
+			e.Amount.setDefault(50)
+			return checkRange("amount", e.Amount.Value, 0, 100)
+		},
+		apply: vignette,
+	},
+	"pixelate": {
+		prepare: func(e *Effect) error {
+			e.Size.setDefault(16)
+			if e.Size.Value != math.Trunc(e.Size.Value) {
+				return fmt.Errorf("size: must be a whole number")
+			}
+			return checkRange("size", e.Size.Value, 1, 10000)
+		},
+		apply: pixelate,
+	},
+	"resize": {
+		prepare: func(e *Effect) error {
+			if e.Width < 0 || e.Height < 0 || e.Width == 0 && e.Height == 0 {
+				return fmt.Errorf("width or height is required")
+			}
+			return nil
+		},
+		apply: resize,
+	},
+	"crop": {
+		prepare: func(e *Effect) error {
+			if e.Width <= 0 || e.Height <= 0 {
+				return fmt.Errorf("width and height are required")
+			}
+			return nil
+		},
+		apply: crop,
+	},
+}
+
+func builtinEffectNames() string {
+	return strings.Join(slices.Sorted(maps.Keys(builtinEffects)), ", ")
+}
+
+// prepare validates the effect and fills in the defaults of its parameters.
+func (e *Effect) prepare() error {
+	if e.Builtin == "" {
+		if len(e.Command) == 0 {
+			return fmt.Errorf("either a command or builtin is required")
+		}
+		return nil
+	}
+	if len(e.Command) > 0 {
+		return fmt.Errorf("a built-in effect can't have a command")
+	}
+	builtin, ok := builtinEffects[e.Builtin]
+	if !ok {
+		return fmt.Errorf("unknown built-in effect %s (available: %s)", e.Builtin, builtinEffectNames())
+	}
+	return builtin.prepare(e)
+}
+
+func checkRange(name string, value, min, max float64) error {
+	if value < min || value > max {
+		return fmt.Errorf("%s: must be between %g and %g", name, min, max)
+	}
+	return nil
+}
+
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// applyBuiltinEffect runs a built-in effect on the wallpaper in process,
+// writing the result to outputPath in the wallpaper's format if it can be
+// encoded, PNG otherwise. The result is rotated upright.
+func applyBuiltinEffect(wp *Wallpaper, effect *Effect, outputPath string) error {
+	// effects of many wallpapers are applied in parallel, and each holds
+	// several copies of the full-size image
+	defer acquireImageSlot()()
+	f, err := os.Open(wp.Path)
+	if err != nil {
+		return err
+	}
+	src, format, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("decoding image: %w", err)
+	}
+	src = applyOrientation(src, wp.Orientation)
+
+	// a rotated image already is a copy the effect can work on
+	img, ok := src.(*image.RGBA)
+	if !ok || img.Bounds().Min != (image.Point{}) {
+		img = image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+		draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	}
+	img = builtinEffects[effect.Builtin].apply(img, effect)
+
+	// PathWithEffect names the output .png in this case
+	if !canEncode(format) {
+		logger.Debugf("cannot encode %s, writing effect output as png", format)
+		format = "png"
+	}
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, img, format); err != nil {
+		return fmt.Errorf("encoding image: %w", err)
+	}
+	return writeFileAtomic(outputPath, buf.Bytes(), 0644)
+}
+
+// parallelRows calls fn for bands of rows in [0, height) in parallel.
+func parallelRows(height int, fn func(y0, y1 int)) {
+	n := min(runtime.NumCPU(), max(height, 1))
+	band := (height + n - 1) / n
+	var wg sync.WaitGroup
+	for y0 := 0; y0 < height; y0 += band {
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			fn(y0, y1)
+		}(y0, min(y0+band, height))
+	}
+	wg.Wait()
+}
+
+// mapPixels applies fn to the premultiplied RGBA values of each pixel.
+func mapPixels(img *image.RGBA, fn func(x, y int, px []uint8)) {
+	b := img.Bounds()
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := img.Pix[y*img.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				fn(x, y, row[x*4:x*4+4])
+			}
+		}
+	})
+}
+
+// clampChannel clamps a premultiplied channel value to [0, alpha].
+func clampChannel(v float64, alpha uint8) uint8 {
+	return uint8(math.Round(max(0, min(v, float64(alpha)))))
+}
+
+func brightnessContrast(img *image.RGBA, e *Effect) *image.RGBA {
+	// same formulas as ImageMagick's -brightness-contrast
+	brightness := e.Amount.Value / 100
+	slope := math.Tan((e.Contrast.Value + 100) * math.Pi / 400)
+	intercept := brightness - slope/2 + 0.5
+	mapPixels(img, func(x, y int, px []uint8) {
+		a := float64(px[3])
+		for i := range 3 {
+			v := float64(px[i]) / 255
+			px[i] = clampChannel((v*slope+intercept*a/255)*255, px[3])
+		}
+	})
+	return img
+}
+
+func desaturate(img *image.RGBA, e *Effect) *image.RGBA {
+	amount := e.Amount.Value / 100
+	mapPixels(img, func(x, y int, px []uint8) {
+		luma := 0.2126*float64(px[0]) + 0.7152*float64(px[1]) + 0.0722*float64(px[2])
+		for i := range 3 {
+			v := float64(px[i])
+			px[i] = clampChannel(v+(luma-v)*amount, px[3])
+		}
+	})
+	return img
+}
+
+func tint(img *image.RGBA, e *Effect) *image.RGBA {
+	amount := e.Amount.Value / 100
+	target := [3]float64{float64(e.color.R), float64(e.color.G), float64(e.color.B)}
+	mapPixels(img, func(x, y int, px []uint8) {
+		a := float64(px[3]) / 255
+		for i := range 3 {
+			v := float64(px[i])
+			px[i] = clampChannel(v+(target[i]*a-v)*amount, px[3])
+		}
+	})
+	return img
+}
+
+func vignette(img *image.RGBA, e *Effect) *image.RGBA {
+	amount := e.Amount.Value / 100
+	b := img.Bounds()
+	cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+	mapPixels(img, func(x, y int, px []uint8) {
+		// normalized so the corners are at distance 1
+		dx, dy := (float64(x)+0.5-cx)/cx, (float64(y)+0.5-cy)/cy
+		d := (dx*dx + dy*dy) / 2
+		factor := 1 - amount*d*d*(3-2*math.Sqrt(d))
+		for i := range 3 {
+			px[i] = clampChannel(float64(px[i])*factor, px[3])
+		}
+	})
+	return img
+}
+
+func pixelate(img *image.RGBA, e *Effect) *image.RGBA {
+	b := img.Bounds()
+	size := int(e.Size.Value)
+	blocks := (b.Dy() + size - 1) / size
+	parallelRows(blocks, func(by0, by1 int) {
+		for by := by0; by < by1; by++ {
+			y0, y1 := by*size, min((by+1)*size, b.Dy())
+			for x0 := 0; x0 < b.Dx(); x0 += size {
+				x1 := min(x0+size, b.Dx())
+				var sum [4]int
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						px := img.Pix[y*img.Stride+x*4:]
+						for i := range 4 {
+							sum[i] += int(px[i])
+						}
+					}
+				}
+				n := (y1 - y0) * (x1 - x0)
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						px := img.Pix[y*img.Stride+x*4:]
+						for i := range 4 {
+							px[i] = uint8(sum[i] / n)
+						}
+					}
+				}
+			}
+		}
+	})
+	return img
+}
+
+// gaussianBlur approximates a gaussian blur with standard deviation sigma by
+// three successive box blurs, which is fast regardless of the radius.
+func gaussianBlur(img *image.RGBA, sigma float64) *image.RGBA {
+	if sigma <= 0 {
+		return img
+	}
+	// box sizes for 3 passes, see "Fast Almost-Gaussian Filtering" (Kovesi)
+	ideal := math.Sqrt(12*sigma*sigma/3 + 1)
+	lower := int(ideal)
+	if lower%2 == 0 {
+		lower--
+	}
+	upper := lower + 2
+	m := int(math.Round((12*sigma*sigma - 3*float64(lower*lower) - 12*float64(lower) - 9) / (-4*float64(lower) - 4)))
+
+	tmp := image.NewRGBA(img.Bounds())
+	for pass := range 3 {
+		size := upper
+		if pass < m {
+			size = lower
+		}
+		radius := (size - 1) / 2
+		boxBlur(img, tmp, radius, true)
+		boxBlur(tmp, img, radius, false)
+	}
+	return img
+}
+
+// boxBlur blurs src into dst along rows if horizontal is set, along columns
+// otherwise. Edges are extended.
+func boxBlur(src, dst *image.RGBA, radius int, horizontal bool) {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	lines, length := h, w
+	offset := func(line, i int) int { return line*src.Stride + i*4 }
+	if !horizontal {
+		lines, length = w, h
+		offset = func(line, i int) int { return i*src.Stride + line*4 }
+	}
+	n := 2*radius + 1
+
+	parallelRows(lines, func(l0, l1 int) {
+		for line := l0; line < l1; line++ {
+			var sum [4]int
+			for i := -radius; i <= radius; i++ {
+				o := offset(line, max(0, min(i, length-1)))
+				for c := range 4 {
+					sum[c] += int(src.Pix[o+c])
+				}
+			}
+			for i := range length {
+				o := offset(line, i)
+				for c := range 4 {
+					dst.Pix[o+c] = uint8(sum[c] / n)
+				}
+				add := offset(line, min(i+radius+1, length-1))
+				remove := offset(line, max(i-radius, 0))
+				for c := range 4 {
+					sum[c] += int(src.Pix[add+c]) - int(src.Pix[remove+c])
+				}
+			}
+		}
+	})
+}
+
+// resize scales the image to fit within the effect's width and height,
+// keeping its aspect ratio. A zero width or height doesn't limit that side.
+func resize(img *image.RGBA, e *Effect) *image.RGBA {
+	b := img.Bounds()
+	scale := math.Inf(1)
+	if e.Width > 0 {
+		scale = float64(e.Width) / float64(b.Dx())
+	}
+	if e.Height > 0 {
+		scale = min(scale, float64(e.Height)/float64(b.Dy()))
+	}
+	w := max(1, int(math.Round(float64(b.Dx())*scale)))
+	h := max(1, int(math.Round(float64(b.Dy())*scale)))
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// crop scales the image to cover the effect's width and height and cuts off
+// what's left over on both sides.
+func crop(img *image.RGBA, e *Effect) *image.RGBA {
+	b := img.Bounds()
+	scale := max(float64(e.Width)/float64(b.Dx()), float64(e.Height)/float64(b.Dy()))
+	// the part of the source that ends up in the output
+	sw := min(b.Dx(), int(math.Round(float64(e.Width)/scale)))
+	sh := min(b.Dy(), int(math.Round(float64(e.Height)/scale)))
+	sx, sy := (b.Dx()-sw)/2, (b.Dy()-sh)/2
+	dst := image.NewRGBA(image.Rect(0, 0, e.Width, e.Height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, image.Rect(sx, sy, sx+sw, sy+sh), draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// EXIF orientations, see the Orientation tag (0x0112) in the EXIF spec.
+// Values 5 to 8 swap width and height.
+const (
+	OrientationNormal     = 1
+	OrientationFlipH      = 2
+	OrientationRotate180  = 3
+	OrientationFlipV      = 4
+	OrientationTranspose  = 5
+	OrientationRotate90   = 6
+	OrientationTransverse = 7
+	OrientationRotate270  = 8
+)
+
+const exifOrientationTag = 0x0112
+
+// Orientation policies for import.orientation.
+const (
+	// Record the orientation and upright resolution, keep the file as is
+	OrientationRecord = "record"
+	// Store a copy of the image rotated upright
+	OrientationRotate = "rotate"
+)
+
+// exifOrientation returns the EXIF orientation of a JPEG or TIFF image, or 0
+// if it has none or the format doesn't carry EXIF data.
+func exifOrientation(r io.ReadSeeker, format string) (int, error) {
+	switch format {
+	case "jpeg":
+		exif, err := jpegExif(r)
+		if err != nil || exif == nil {
+			return 0, err
+		}
+		return tiffOrientation(bytes.NewReader(exif))
+	case "tiff":
+		return tiffOrientation(r)
+	}
+	return 0, nil
+}
+
+// jpegExif returns the TIFF structure of the APP1 EXIF segment of a JPEG, or
+// nil if there is none.
+func jpegExif(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi != [2]byte{0xff, 0xd8} {
+		return nil, fmt.Errorf("not a jpeg")
+	}
+
+	for {
+		marker, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if marker != 0xff {
+			return nil, fmt.Errorf("invalid jpeg marker")
+		}
+		kind, err := br.ReadByte()
+		for err == nil && kind == 0xff {
+			// fill bytes
+			kind, err = br.ReadByte()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if kind == 0xd8 || (kind >= 0xd0 && kind <= 0xd7) {
+			continue
+		}
+		if kind == 0xda || kind == 0xd9 {
+			// start of scan or end of image, metadata is over
+			return nil, nil
+		}
+
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if length < 2 {
+			return nil, fmt.Errorf("invalid jpeg segment length")
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			return nil, err
+		}
+		if kind == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of a TIFF
+// structure.
+func tiffOrientation(r io.ReadSeeker) (int, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, fmt.Errorf("invalid tiff byte order")
+	}
+	if order.Uint16(header[2:4]) != 42 {
+		return 0, fmt.Errorf("invalid tiff header")
+	}
+
+	if _, err := r.Seek(int64(order.Uint32(header[4:8])), io.SeekStart); err != nil {
+		return 0, err
+	}
+	var count uint16
+	if err := binary.Read(r, order, &count); err != nil {
+		return 0, err
+	}
+	for range count {
+		var entry [12]byte
+		if _, err := io.ReadFull(r, entry[:]); err != nil {
+			return 0, err
+		}
+		// a SHORT value is stored in the first two bytes of the value field
+		const typeShort = 3
+		if order.Uint16(entry[0:2]) == exifOrientationTag && order.Uint16(entry[2:4]) == typeShort {
+			orientation := int(order.Uint16(entry[8:10]))
+			if orientation < OrientationNormal || orientation > OrientationRotate270 {
+				return 0, nil
+			}
+			return orientation, nil
+		}
+	}
+	return 0, nil
+}
+
+// orientationSwapsAxes reports whether displaying an image with the given
+// orientation swaps its width and height.
+func orientationSwapsAxes(orientation int) bool {
+	return orientation >= OrientationTranspose
+}
+
+// applyOrientation returns img transformed so it displays upright without
+// its EXIF orientation. Pixels are copied as raw bytes; images other than
+// RGBA and NRGBA, such as decoded JPEGs, are converted to RGBA first.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= OrientationNormal {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dstRect := image.Rect(0, 0, w, h)
+	if orientationSwapsAxes(orientation) {
+		dstRect = image.Rect(0, 0, h, w)
+	}
+
+	var src, dst []uint8
+	var srcStride, dstStride int
+	var out image.Image
+	switch img := img.(type) {
+	case *image.NRGBA:
+		d := image.NewNRGBA(dstRect)
+		src, srcStride = img.Pix[img.PixOffset(b.Min.X, b.Min.Y):], img.Stride
+		dst, dstStride, out = d.Pix, d.Stride, d
+	case *image.RGBA:
+		d := image.NewRGBA(dstRect)
+		src, srcStride = img.Pix[img.PixOffset(b.Min.X, b.Min.Y):], img.Stride
+		dst, dstStride, out = d.Pix, d.Stride, d
+	default:
+		// draw has fast paths for converting YCbCr and paletted images
+		s := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(s, s.Bounds(), img, b.Min, draw.Src)
+		d := image.NewRGBA(dstRect)
+		src, srcStride = s.Pix, s.Stride
+		dst, dstStride, out = d.Pix, d.Stride, d
+	}
+
+	// the destination of (x, y) is dx0+dxx*x+dxy*y, dy0+dyx*x+dyy*y
+	var dx0, dxx, dxy, dy0, dyx, dyy int
+	switch orientation {
+	case OrientationFlipH:
+		dx0, dxx, dyy = w-1, -1, 1
+	case OrientationRotate180:
+		dx0, dxx, dy0, dyy = w-1, -1, h-1, -1
+	case OrientationFlipV:
+		dxx, dy0, dyy = 1, h-1, -1
+	case OrientationTranspose:
+		dxy, dyx = 1, 1
+	case OrientationRotate90:
+		dx0, dxy, dyx = h-1, -1, 1
+	case OrientationTransverse:
+		dx0, dxy, dy0, dyx = h-1, -1, w-1, -1
+	case OrientationRotate270:
+		dxy, dy0, dyx = 1, w-1, -1
+	}
+	origin := dy0*dstStride + dx0*4
+	xStep, yStep := dyx*dstStride+dxx*4, dyy*dstStride+dxy*4
+
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := src[y*srcStride : y*srcStride+w*4]
+			i := origin + y*yStep
+			for x := 0; x < len(row); x += 4 {
+				copy(dst[i:i+4], row[x:x+4])
+				i += xStep
+			}
+		}
+	})
+	return out
+}
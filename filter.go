@@ -0,0 +1,558 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a compiled filter expression matching wallpapers, e.g.
+//
+//	tag:nature and width>=2560 and type=image/png and not disabled
+//
+// Terms are combined with and, or, not and parentheses. A term is a boolean
+// field (enabled), a comparison (width>=2560, id~"forest*") or a tag test
+// (tag:dark, tag:source=unsplash).
+type Filter struct {
+	Source string
+	expr   filterExpr
+}
+
+// FilterError is a syntax or type error in a filter expression. Column is the
+// 1-based column (in runes) where the error occurred.
+type FilterError struct {
+	Column  int
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Message)
+}
+
+// ParseFilter parses and type-checks a filter expression.
+func ParseFilter(src string) (*Filter, error) {
+	tokens, err := lexFilter(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, &FilterError{Column: 1, Message: "empty filter"}
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.unexpected(tok)
+	}
+	return &Filter{Source: src, expr: expr}, nil
+}
+
+// Match reports whether the wallpaper matches the filter.
+func (f *Filter) Match(wp *Wallpaper) bool {
+	return f.expr.match(wp)
+}
+
+// parseFilterFlag parses a filter given on the command line, returning nil if
+// src is empty. Errors point at the offending column.
+func parseFilterFlag(src string) (*Filter, error) {
+	if src == "" {
+		return nil, nil
+	}
+	f, err := ParseFilter(src)
+	if err != nil {
+		if ferr, ok := err.(*FilterError); ok {
+			return nil, fmt.Errorf("invalid filter: %w\n  %s\n  %s^", err, src, strings.Repeat(" ", ferr.Column-1))
+		}
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return f, nil
+}
+
+// matchAll combines matchers into one that matches wallpapers matched by all
+// of them. Nil matchers are ignored; if all are nil, nil is returned.
+func matchAll(matchers ...func(*Wallpaper) bool) func(*Wallpaper) bool {
+	matchers = slices.DeleteFunc(matchers, func(m func(*Wallpaper) bool) bool {
+		return m == nil
+	})
+	if len(matchers) == 0 {
+		return nil
+	}
+	return func(wp *Wallpaper) bool {
+		for _, m := range matchers {
+			if !m(wp) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// filterWallpapers returns the wallpapers matched by match, or all of them if
+// match is nil.
+func filterWallpapers(wps []*Wallpaper, match func(*Wallpaper) bool) []*Wallpaper {
+	if match == nil {
+		return wps
+	}
+	return slices.DeleteFunc(slices.Clone(wps), func(wp *Wallpaper) bool {
+		return !match(wp)
+	})
+}
+
+type fieldKind int
+
+const (
+	fieldString fieldKind = iota
+	fieldInt
+	fieldFloat
+	fieldBool
+	fieldResolution
+)
+
+type filterField struct {
+	kind fieldKind
+	get  func(wp *Wallpaper) any
+}
+
+// filterFields are the wallpaper fields available in filter expressions. Tags
+// are handled separately with the tag:key syntax.
+var filterFields = map[string]filterField{
+	"id":       {fieldString, func(wp *Wallpaper) any { return wp.Id }},
+	"path":     {fieldString, func(wp *Wallpaper) any { return wp.Path }},
+	"original": {fieldString, func(wp *Wallpaper) any { return wp.OriginalFilename }},
+	"type":     {fieldString, func(wp *Wallpaper) any { return wp.MimeType }},
+	"hash":     {fieldString, func(wp *Wallpaper) any { return wp.Hash }},
+	"mode":     {fieldString, func(wp *Wallpaper) any { return wp.ImportMode() }},
+	"source":   {fieldString, func(wp *Wallpaper) any { return wp.Source }},
+	"width":    {fieldInt, func(wp *Wallpaper) any { return wp.Resolution.Width }},
+	"height":   {fieldInt, func(wp *Wallpaper) any { return wp.Resolution.Height }},
+	"pixels": {fieldInt, func(wp *Wallpaper) any {
+		return wp.Resolution.Width * wp.Resolution.Height
+	}},
+	"ratio": {fieldFloat, func(wp *Wallpaper) any {
+		if wp.Resolution.Height == 0 {
+			return 0.0
+		}
+		return float64(wp.Resolution.Width) / float64(wp.Resolution.Height)
+	}},
+	"resolution": {fieldResolution, func(wp *Wallpaper) any { return wp.Resolution }},
+	"weight":     {fieldFloat, func(wp *Wallpaper) any { return wp.EffectiveWeight() }},
+	"rating":     {fieldInt, func(wp *Wallpaper) any { return wp.Rating }},
+	"favorite":   {fieldBool, func(wp *Wallpaper) any { return wp.Favorite }},
+	"enabled":    {fieldBool, func(wp *Wallpaper) any { return wp.Enabled }},
+	"disabled":   {fieldBool, func(wp *Wallpaper) any { return !wp.Enabled }},
+}
+
+var filterFieldAliases = map[string]string{
+	"mime":   "type",
+	"w":      "width",
+	"h":      "height",
+	"aspect": "ratio",
+	"res":    "resolution",
+	"fav":    "favorite",
+}
+
+// expressions
+
+type filterExpr interface {
+	match(wp *Wallpaper) bool
+}
+
+type andExpr struct{ left, right filterExpr }
+
+func (e *andExpr) match(wp *Wallpaper) bool { return e.left.match(wp) && e.right.match(wp) }
+
+type orExpr struct{ left, right filterExpr }
+
+func (e *orExpr) match(wp *Wallpaper) bool { return e.left.match(wp) || e.right.match(wp) }
+
+type notExpr struct{ expr filterExpr }
+
+func (e *notExpr) match(wp *Wallpaper) bool { return !e.expr.match(wp) }
+
+type funcExpr func(wp *Wallpaper) bool
+
+func (e funcExpr) match(wp *Wallpaper) bool { return e(wp) }
+
+// lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	col  int
+	// whether the token directly follows the previous one, without whitespace
+	adjacent bool
+}
+
+func isFilterOpRune(r rune) bool {
+	return strings.ContainsRune("=!<>~", r)
+}
+
+func lexFilter(src string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(src)
+	adjacent := false
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		col := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			adjacent = false
+			continue
+		case r == '(':
+			tokens = append(tokens, filterToken{tokLParen, "(", col, adjacent})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{tokRParen, ")", col, adjacent})
+			i++
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, &FilterError{Column: col, Message: "unterminated string"}
+			}
+			tokens = append(tokens, filterToken{tokString, sb.String(), col, adjacent})
+			i = j + 1
+		case isFilterOpRune(r):
+			j := i + 1
+			if j < len(runes) && runes[j] == '=' && r != '=' && r != '~' {
+				j++
+			}
+			op := string(runes[i:j])
+			if op == "!" {
+				return nil, &FilterError{Column: col, Message: `unexpected "!" (use "not" or "!=")`}
+			}
+			tokens = append(tokens, filterToken{tokOp, op, col, adjacent})
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !isFilterOpRune(runes[j]) &&
+				!strings.ContainsRune(`()"'`, runes[j]) {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokWord, string(runes[i:j]), col, adjacent})
+			i = j
+		}
+		adjacent = true
+	}
+	tokens = append(tokens, filterToken{tokEOF, "", len(runes) + 1, false})
+	return tokens, nil
+}
+
+// parser
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) isKeyword(tok filterToken, keyword string) bool {
+	return tok.kind == tokWord && strings.EqualFold(tok.text, keyword)
+}
+
+func (p *filterParser) unexpected(tok filterToken) error {
+	if tok.kind == tokEOF {
+		return &FilterError{Column: tok.col, Message: "unexpected end of filter"}
+	}
+	return &FilterError{Column: tok.col, Message: fmt.Sprintf("unexpected %q", tok.text)}
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(p.peek(), "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if p.isKeyword(p.peek(), "not") {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterExpr, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == tokLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			if closing.kind == tokEOF {
+				return nil, &FilterError{Column: tok.col, Message: "unclosed parenthesis"}
+			}
+			return nil, p.unexpected(closing)
+		}
+		return expr, nil
+	case tok.kind == tokWord && !p.isKeyword(tok, "and") && !p.isKeyword(tok, "or"):
+		return p.parseTerm(tok)
+	default:
+		return nil, p.unexpected(tok)
+	}
+}
+
+// parseTerm parses a field test starting with the field name token.
+func (p *filterParser) parseTerm(fieldTok filterToken) (filterExpr, error) {
+	name := strings.ToLower(fieldTok.text)
+
+	if key, ok := strings.CutPrefix(name, "tag:"); ok {
+		// keep the original case of the key
+		key = fieldTok.text[len("tag:"):]
+		keyCol := fieldTok.col + len("tag:")
+		if key == "" {
+			if tok := p.peek(); tok.kind == tokString && tok.adjacent {
+				key = p.next().text
+			}
+		}
+		if key == "" {
+			return nil, &FilterError{Column: keyCol, Message: "missing tag name after tag:"}
+		}
+		return p.parseTagTest(key)
+	}
+
+	if alias, ok := filterFieldAliases[name]; ok {
+		name = alias
+	}
+	field, ok := filterFields[name]
+	if !ok {
+		return nil, &FilterError{Column: fieldTok.col, Message: fmt.Sprintf("unknown field %q", fieldTok.text)}
+	}
+
+	opTok := p.peek()
+	if opTok.kind != tokOp {
+		if field.kind != fieldBool {
+			return nil, &FilterError{Column: fieldTok.col, Message: fmt.Sprintf("field %s needs a comparison, e.g. %s=value", name, name)}
+		}
+		return funcExpr(func(wp *Wallpaper) bool { return field.get(wp).(bool) }), nil
+	}
+	p.next()
+
+	valueTok := p.next()
+	if valueTok.kind != tokWord && valueTok.kind != tokString {
+		return nil, &FilterError{Column: valueTok.col, Message: fmt.Sprintf("expected a value after %s", opTok.text)}
+	}
+
+	return compareField(name, field, opTok, valueTok)
+}
+
+func (p *filterParser) parseTagTest(key string) (filterExpr, error) {
+	opTok := p.peek()
+	if opTok.kind != tokOp {
+		return funcExpr(func(wp *Wallpaper) bool { return wp.HasTag(key, "", false) }), nil
+	}
+	p.next()
+	valueTok := p.next()
+	if valueTok.kind != tokWord && valueTok.kind != tokString {
+		return nil, &FilterError{Column: valueTok.col, Message: fmt.Sprintf("expected a value after %s", opTok.text)}
+	}
+	value := valueTok.text
+
+	switch opTok.text {
+	case "=":
+		return funcExpr(func(wp *Wallpaper) bool { return wp.HasTag(key, value, true) }), nil
+	case "!=":
+		return funcExpr(func(wp *Wallpaper) bool { return !wp.HasTag(key, value, true) }), nil
+	case "~":
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, &FilterError{Column: valueTok.col, Message: fmt.Sprintf("invalid pattern %q", value)}
+		}
+		return funcExpr(func(wp *Wallpaper) bool {
+			v, ok := wp.Tags[key]
+			if !ok {
+				return false
+			}
+			matched, _ := path.Match(value, v)
+			return matched
+		}), nil
+	default:
+		return nil, &FilterError{Column: opTok.col, Message: fmt.Sprintf("operator %s is not supported for tags", opTok.text)}
+	}
+}
+
+func compareField(name string, field filterField, opTok, valueTok filterToken) (filterExpr, error) {
+	op := opTok.text
+	value := valueTok.text
+	unsupported := &FilterError{Column: opTok.col, Message: fmt.Sprintf("operator %s is not supported for field %s", op, name)}
+	invalid := func(what string) error {
+		return &FilterError{Column: valueTok.col, Message: fmt.Sprintf("invalid %s %q for field %s", what, value, name)}
+	}
+
+	switch field.kind {
+	case fieldString:
+		switch op {
+		case "=":
+			return funcExpr(func(wp *Wallpaper) bool { return field.get(wp).(string) == value }), nil
+		case "!=":
+			return funcExpr(func(wp *Wallpaper) bool { return field.get(wp).(string) != value }), nil
+		case "~":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, invalid("pattern")
+			}
+			return funcExpr(func(wp *Wallpaper) bool {
+				matched, _ := path.Match(value, field.get(wp).(string))
+				return matched
+			}), nil
+		}
+		return nil, unsupported
+
+	case fieldInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, invalid("number")
+		}
+		cmp, ok := compareOp(op)
+		if !ok {
+			return nil, unsupported
+		}
+		return funcExpr(func(wp *Wallpaper) bool {
+			return cmp(float64(field.get(wp).(int)), float64(n))
+		}), nil
+
+	case fieldFloat:
+		f, err := parseRatio(value)
+		if err != nil {
+			return nil, invalid("number")
+		}
+		cmp, ok := compareOp(op)
+		if !ok {
+			return nil, unsupported
+		}
+		return funcExpr(func(wp *Wallpaper) bool { return cmp(field.get(wp).(float64), f) }), nil
+
+	case fieldBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalid("boolean")
+		}
+		switch op {
+		case "=":
+			return funcExpr(func(wp *Wallpaper) bool { return field.get(wp).(bool) == b }), nil
+		case "!=":
+			return funcExpr(func(wp *Wallpaper) bool { return field.get(wp).(bool) != b }), nil
+		}
+		return nil, unsupported
+
+	case fieldResolution:
+		var r Resolution
+		if _, err := fmt.Sscanf(value, "%dx%d", &r.Width, &r.Height); err != nil {
+			return nil, invalid("resolution")
+		}
+		if op == "!=" {
+			return funcExpr(func(wp *Wallpaper) bool { return field.get(wp).(Resolution) != r }), nil
+		}
+		cmp, ok := compareOp(op)
+		if !ok {
+			return nil, unsupported
+		}
+		// both dimensions must satisfy the comparison
+		return funcExpr(func(wp *Wallpaper) bool {
+			res := field.get(wp).(Resolution)
+			return cmp(float64(res.Width), float64(r.Width)) && cmp(float64(res.Height), float64(r.Height))
+		}), nil
+	}
+
+	return nil, unsupported
+}
+
+func compareOp(op string) (func(a, b float64) bool, bool) {
+	switch op {
+	case "=":
+		return func(a, b float64) bool { return a == b }, true
+	case "!=":
+		return func(a, b float64) bool { return a != b }, true
+	case "<":
+		return func(a, b float64) bool { return a < b }, true
+	case "<=":
+		return func(a, b float64) bool { return a <= b }, true
+	case ">":
+		return func(a, b float64) bool { return a > b }, true
+	case ">=":
+		return func(a, b float64) bool { return a >= b }, true
+	}
+	return nil, false
+}
+
+// parseRatio parses a number or a ratio of the form a:b (e.g. 16:9).
+func parseRatio(s string) (float64, error) {
+	if a, b, ok := strings.Cut(s, ":"); ok {
+		num, err := strconv.ParseFloat(a, 64)
+		if err != nil {
+			return 0, err
+		}
+		den, err := strconv.ParseFloat(b, 64)
+		if err != nil {
+			return 0, err
+		}
+		if den == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return num / den, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
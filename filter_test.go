@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", "column 1: empty filter"},
+		{"width>=", "column 8: expected a value after >="},
+		{"(enabled", "column 1: unclosed parenthesis"},
+		{"enabled and (favorite or", "column 25: unexpected end of filter"},
+		{"enabled)", `column 8: unexpected ")"`},
+		{"color=red", `column 1: unknown field "color"`},
+		{"width", "column 1: field width needs a comparison, e.g. width=value"},
+		{"tag:", "column 5: missing tag name after tag:"},
+		{"tag:mood>=dark", "column 9: operator >= is not supported for tags"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := ParseFilter(tt.src)
+			var ferr *FilterError
+			if !errors.As(err, &ferr) {
+				t.Fatalf("ParseFilter(%q) error = %v, want a FilterError", tt.src, err)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("ParseFilter(%q) error = %q, want %q", tt.src, err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	wp := &Wallpaper{
+		Id:         "forest-dawn",
+		Enabled:    true,
+		Rating:     4,
+		Resolution: Resolution{Width: 3840, Height: 2160},
+		Tags:       map[string]string{"nature": "", "source": "unsplash"},
+	}
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"enabled", true},
+		{"not enabled", false},
+		{"width>=2560 and height>=1440", true},
+		{"width>3840", false},
+		{"rating>=4 and not favorite", true},
+		{`id~"forest*"`, true},
+		{"tag:nature", true},
+		{"tag:source=unsplash", true},
+		{"tag:source!=unsplash", false},
+		{"tag:city or tag:nature", true},
+		{"(tag:city or tag:nature) and disabled", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			f, err := ParseFilter(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(wp); got != tt.want {
+				t.Errorf("Match = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFilterPrecedence checks that not binds tighter than and, which binds
+// tighter than or, so "a or b and not c" means "a or (b and (not c))".
+func TestFilterPrecedence(t *testing.T) {
+	f, err := ParseFilter("tag:a or tag:b and not tag:c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []bool{false, true} {
+		for _, b := range []bool{false, true} {
+			for _, c := range []bool{false, true} {
+				wp := &Wallpaper{Tags: map[string]string{}}
+				for key, set := range map[string]bool{"a": a, "b": b, "c": c} {
+					if set {
+						wp.Tags[key] = ""
+					}
+				}
+				want := a || (b && !c)
+				if got := f.Match(wp); got != want {
+					t.Errorf("a=%t b=%t c=%t: Match = %t, want %t", a, b, c, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type FsckKind string
+
+const (
+	// A wallpaper in the store whose source file doesn't exist
+	FsckMissingSource FsckKind = "missing-source"
+	// A file in the sources directory that no wallpaper refers to
+	FsckOrphanSource FsckKind = "orphan-source"
+	// A cache directory for an effect that is no longer configured
+	FsckStaleEffect FsckKind = "stale-effect"
+	// A file in an effect cache directory that no wallpaper refers to
+	FsckOrphanCache FsckKind = "orphan-cache"
+	// A wallpaper whose stored metadata doesn't match its source file
+	FsckMetadata FsckKind = "metadata-mismatch"
+)
+
+type FsckProblem struct {
+	Kind FsckKind `json:"kind"`
+	// Id of the wallpaper the problem concerns, if any
+	Id      string `json:"id,omitempty"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	// Whether the problem was repaired
+	Repaired bool `json:"repaired"`
+	// Error encountered while repairing the problem, if any
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+type FsckReport struct {
+	Problems []*FsckProblem `json:"problems"`
+}
+
+// Unrepaired returns the number of problems that were not repaired.
+func (r *FsckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *FsckReport) add(p *FsckProblem, repair func() error) {
+	r.Problems = append(r.Problems, p)
+	if repair == nil {
+		return
+	}
+	if err := repair(); err != nil {
+		p.RepairError = err.Error()
+		return
+	}
+	p.Repaired = true
+}
+
+// Fsck checks that the store, the sources directory and the effect cache agree
+// with each other. If repair is true, each problem found is fixed as well.
+func (w *Walls) Fsck(ctx context.Context, repair bool) (*FsckReport, error) {
+	report := &FsckReport{Problems: []*FsckProblem{}}
+	// repairIf returns fn if repairing, nil otherwise
+	repairIf := func(fn func() error) func() error {
+		if repair {
+			return fn
+		}
+		return nil
+	}
+
+	w.fsckWallpapers(ctx, report, repairIf)
+	if err := w.fsckSources(ctx, report, repairIf); err != nil {
+		return report, err
+	}
+	if err := w.fsckCache(ctx, report, repairIf); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+func (w *Walls) fsckWallpapers(ctx context.Context, report *FsckReport, repairIf func(func() error) func() error) {
+	for _, wp := range slices.Clone(w.Store.Wallpapers) {
+		info, err := probeImageFile(wp.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			w.fsckMissingSource(ctx, wp, report, repairIf)
+			continue
+		}
+		if err != nil {
+			report.add(&FsckProblem{
+				Kind:    FsckMetadata,
+				Id:      wp.Id,
+				Path:    wp.Path,
+				Message: fmt.Sprintf("reading source file: %s", err),
+			}, nil)
+			continue
+		}
+
+		if wp.ImportMode() == ImportSymlink {
+			if stat, err := os.Lstat(wp.Path); err == nil && stat.Mode()&os.ModeSymlink == 0 {
+				report.add(&FsckProblem{
+					Kind:    FsckMetadata,
+					Id:      wp.Id,
+					Path:    wp.Path,
+					Message: "imported as a symlink, but the source is a regular file",
+				}, repairIf(func() error {
+					wp.Mode = ImportCopy
+					return nil
+				}))
+			}
+		}
+		if wp.Resolution != info.Resolution {
+			report.add(&FsckProblem{
+				Kind:    FsckMetadata,
+				Id:      wp.Id,
+				Path:    wp.Path,
+				Message: fmt.Sprintf("stored resolution %s, actual %s", wp.Resolution, info.Resolution),
+			}, repairIf(func() error {
+				wp.Resolution = info.Resolution
+				return nil
+			}))
+		}
+		if wp.MimeType != info.MimeType {
+			report.add(&FsckProblem{
+				Kind:    FsckMetadata,
+				Id:      wp.Id,
+				Path:    wp.Path,
+				Message: fmt.Sprintf("stored type %s, actual %s", wp.MimeType, info.MimeType),
+			}, repairIf(func() error {
+				wp.MimeType = info.MimeType
+				return nil
+			}))
+		}
+		if wp.Hash != "" && wp.Hash != info.Hash {
+			report.add(&FsckProblem{
+				Kind:    FsckMetadata,
+				Id:      wp.Id,
+				Path:    wp.Path,
+				Message: fmt.Sprintf("stored hash %s, actual %s", wp.Hash, info.Hash),
+			}, repairIf(func() error {
+				wp.Hash = info.Hash
+				return nil
+			}))
+		}
+	}
+}
+
+// fsckMissingSource reports a wallpaper whose file doesn't exist. Repairing
+// removes it from the store, along with a dangling symlink in the sources
+// directory. A referenced file in a directory that has disappeared as a whole
+// (e.g. an unmounted network share) is reported but never repaired.
+func (w *Walls) fsckMissingSource(ctx context.Context, wp *Wallpaper, report *FsckReport, repairIf func(func() error) func() error) {
+	problem := &FsckProblem{
+		Kind:    FsckMissingSource,
+		Id:      wp.Id,
+		Path:    wp.Path,
+		Message: "source file does not exist",
+	}
+	repair := func() error {
+		w.removeFromStore(wp)
+		return w.deleteCached(ctx, wp)
+	}
+
+	switch wp.ImportMode() {
+	case ImportSymlink:
+		problem.Message = "symlink target does not exist"
+		repair = func() error {
+			w.removeFromStore(wp)
+			if err := os.Remove(wp.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return w.deleteCached(ctx, wp)
+		}
+	case ImportReference:
+		problem.Message = "referenced file does not exist"
+		if _, err := os.Stat(filepath.Dir(wp.Path)); err != nil {
+			problem.Message = fmt.Sprintf("directory of referenced file is not available (%s)", err)
+			repair = nil
+		}
+	}
+
+	report.add(problem, repairIf(repair))
+}
+
+func (w *Walls) fsckSources(ctx context.Context, report *FsckReport, repairIf func(func() error) func() error) error {
+	sourcesDir := filepath.Join(w.Config.Storage.Sources, "sources")
+	entries, err := os.ReadDir(sourcesDir)
+	if err != nil {
+		return fmt.Errorf("reading sources directory: %w", err)
+	}
+
+	referenced := make(map[string]bool, len(w.Store.Wallpapers))
+	for _, wp := range w.Store.Wallpapers {
+		referenced[filepath.Clean(wp.Path)] = true
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(sourcesDir, entry.Name())
+		if entry.IsDir() || referenced[path] {
+			continue
+		}
+		report.add(&FsckProblem{
+			Kind:    FsckOrphanSource,
+			Path:    path,
+			Message: "file is not referenced by any wallpaper",
+		}, repairIf(func() error {
+			return w.adoptSource(ctx, path)
+		}))
+	}
+
+	return nil
+}
+
+// adoptSource adds an orphaned file in the sources directory to the store. If
+// the file is a copy of an existing wallpaper, it is deleted instead.
+func (w *Walls) adoptSource(ctx context.Context, path string) error {
+	info, err := probeImageFile(path)
+	if err != nil {
+		return err
+	}
+
+	if dup := w.WallpaperByHash(ctx, info.Hash); dup != nil {
+		logger.Debugf("orphan %s is identical to wallpaper %s, deleting", path, dup.Id)
+		return os.Remove(path)
+	}
+
+	base := filepath.Base(path)
+	id := strings.TrimSuffix(base, filepath.Ext(base))
+	for _, wp := range w.Store.Wallpapers {
+		if wp.Id == id {
+			return fmt.Errorf("a wallpaper with the id %s already exists", id)
+		}
+	}
+
+	mode := ImportCopy
+	if stat, err := os.Lstat(path); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+		mode = ImportSymlink
+	}
+
+	logger.Debugf("adopting orphan %s as wallpaper %s", path, id)
+	w.Store.Wallpapers = append(w.Store.Wallpapers, &Wallpaper{
+		Id:               id,
+		Path:             path,
+		OriginalFilename: base,
+		MimeType:         info.MimeType,
+		Resolution:       info.Resolution,
+		Enabled:          true,
+		Hash:             info.Hash,
+		Mode:             mode,
+	})
+	return nil
+}
+
+func (w *Walls) fsckCache(ctx context.Context, report *FsckReport, repairIf func(func() error) func() error) error {
+	entries, err := os.ReadDir(w.Config.Storage.Cache)
+	if err != nil {
+		return fmt.Errorf("reading cache directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(w.Config.Storage.Cache, entry.Name())
+		if !entry.IsDir() {
+			continue
+		}
+		effect := entry.Name()
+		if effect == thumbsCacheDir {
+			if err := w.fsckThumbs(ctx, report, repairIf); err != nil {
+				return fmt.Errorf("checking thumbnails: %w", err)
+			}
+			continue
+		}
+		if slices.Contains(reservedCacheDirs, effect) {
+			continue
+		}
+		if _, ok := w.Config.Effects.Effects[effect]; !ok {
+			report.add(&FsckProblem{
+				Kind:    FsckStaleEffect,
+				Path:    path,
+				Message: fmt.Sprintf("effect %s is not configured", effect),
+			}, repairIf(func() error {
+				return os.RemoveAll(path)
+			}))
+			continue
+		}
+
+		expected := make(map[string]bool, len(w.Store.Wallpapers))
+		for _, wp := range w.Store.Wallpapers {
+			expected[wp.PathWithEffect(ctx, effect)] = true
+		}
+		files, err := os.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("reading effect cache directory %s: %w", path, err)
+		}
+		for _, file := range files {
+			filePath := filepath.Join(path, file.Name())
+			if file.IsDir() || expected[filePath] {
+				continue
+			}
+			report.add(&FsckProblem{
+				Kind:    FsckOrphanCache,
+				Path:    filePath,
+				Message: "cached file is not referenced by any wallpaper",
+			}, repairIf(func() error {
+				return os.Remove(filePath)
+			}))
+		}
+	}
+
+	return nil
+}
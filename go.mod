@@ -21,6 +21,7 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/prometheus/procfs v0.19.2
 	golang.org/x/image v0.34.0
+	golang.org/x/text v0.32.0
 )
 
 replace github.com/calico32/kdl-go => ../kdl-go
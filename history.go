@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultHistorySize is the number of history entries kept if
+// behavior.history-size is not set.
+const defaultHistorySize = 100
+
+type HistoryEntry struct {
+	Id     string    `json:"id"`
+	Effect string    `json:"effect"`
+	SetAt  time.Time `json:"set_at"`
+}
+
+// History is the list of wallpapers set, oldest first. Every wallpaper set
+// with SetWallpaper is appended; moving back and forth with prev and next only
+// moves Position.
+type History struct {
+	Entries []HistoryEntry `json:"entries"`
+	// Index of the entry in Entries that is currently shown
+	Position int `json:"position"`
+}
+
+func (w *Walls) historyPath() string {
+	return filepath.Join(w.Config.Storage.Sources, "history.json")
+}
+
+// LoadHistory reads the history from the data directory.
+func (w *Walls) LoadHistory(ctx context.Context) (*History, error) {
+	data, err := os.ReadFile(w.historyPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return &History{Entries: []HistoryEntry{}, Position: -1}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history file: %w", err)
+	}
+
+	var history History
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("parsing history file %s: %w", w.historyPath(), err)
+	}
+	if history.Position < 0 || history.Position >= len(history.Entries) {
+		history.Position = len(history.Entries) - 1
+	}
+	return &history, nil
+}
+
+// WriteHistory writes the history to the data directory.
+func (w *Walls) WriteHistory(ctx context.Context, history *History) error {
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling history: %w", err)
+	}
+	logger.Debugf("writing history with %d entries to %s", len(history.Entries), w.historyPath())
+	if err := writeFileAtomic(w.historyPath(), data, 0644); err != nil {
+		return fmt.Errorf("writing history file: %w", err)
+	}
+	return nil
+}
+
+// RecordHistory appends the wallpaper described by state to the history,
+// dropping the oldest entries beyond behavior.history-size.
+func (w *Walls) RecordHistory(ctx context.Context, state *State) error {
+	history, err := w.LoadHistory(ctx)
+	if err != nil {
+		return err
+	}
+
+	history.Entries = append(history.Entries, HistoryEntry{
+		Id:     state.Id,
+		Effect: state.Effect,
+		SetAt:  state.SetAt,
+	})
+	size := w.Config.Behavior.HistorySize
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	if len(history.Entries) > size {
+		history.Entries = history.Entries[len(history.Entries)-size:]
+	}
+	history.Position = len(history.Entries) - 1
+
+	return w.WriteHistory(ctx, history)
+}
+
+// StepHistory moves steps entries through the history (backwards if steps is
+// negative) and sets the wallpaper found there. Entries for wallpapers that no
+// longer exist, and entries for the wallpaper already shown, are skipped.
+func (w *Walls) StepHistory(ctx context.Context, steps int) (*Wallpaper, error) {
+	history, err := w.LoadHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(history.Entries) == 0 {
+		return nil, fmt.Errorf("history is empty")
+	}
+
+	dir := 1
+	if steps < 0 {
+		dir = -1
+		steps = -steps
+	}
+
+	pos := history.Position
+	shown := history.Entries[pos].Id
+	var wp *Wallpaper
+	for steps > 0 {
+		pos += dir
+		if pos < 0 || pos >= len(history.Entries) {
+			if dir < 0 {
+				return nil, fmt.Errorf("already at the oldest wallpaper in the history")
+			}
+			return nil, fmt.Errorf("already at the newest wallpaper in the history")
+		}
+		entry := history.Entries[pos]
+		candidate := w.FindWallpaper(entry.Id)
+		if candidate == nil || entry.Id == shown {
+			continue
+		}
+		wp = candidate
+		shown = entry.Id
+		steps--
+	}
+
+	if _, err := w.applyWallpaper(ctx, wp); err != nil {
+		return nil, err
+	}
+
+	history.Position = pos
+	if err := w.WriteHistory(ctx, history); err != nil {
+		return wp, err
+	}
+	return wp, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Id conflict policies for import.on-conflict, deciding what happens when the
+// id derived from a filename is already taken.
+const (
+	// Fail to add the wallpaper
+	ConflictError = "error"
+	// Append the first free number, e.g. name-2
+	ConflictSuffix = "suffix"
+	// Append a short hash of the contents, e.g. name-3fa2c1d0, and a number
+	// after it if that is taken as well
+	ConflictHash = "hash"
+)
+
+var conflictPolicies = []string{ConflictError, ConflictSuffix, ConflictHash}
+
+// foldedRunes maps letters that don't decompose into a base letter and
+// combining marks, and ligatures, to ASCII.
+var foldedRunes = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'þ': "th", 'ð': "d", 'đ': "d",
+	'ħ': "h", 'ı': "i", 'ł': "l", 'ø': "o", 'ŧ': "t",
+}
+
+// slugify turns a filename (without extension) into an id: lowercase, with
+// diacritics folded to ASCII and runs of anything but letters, digits and
+// underscores replaced by a single dash. Both precomposed and decomposed
+// (NFD, as written by macOS) accents are folded.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFKD.String(strings.ToLower(name)) {
+		if unicode.Is(unicode.Mn, r) {
+			// combining marks of decomposed letters
+			continue
+		}
+		if folded, ok := foldedRunes[r]; ok {
+			b.WriteString(folded)
+			dash = false
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimRight(b.String(), "-")
+	if slug == "" {
+		return "wallpaper"
+	}
+	return slug
+}
+
+// validateId checks that an id given explicitly can be used as a filename.
+func validateId(id string) error {
+	if id == "" {
+		return fmt.Errorf("id must not be empty")
+	}
+	if strings.HasPrefix(id, ".") || strings.HasPrefix(id, "-") {
+		return fmt.Errorf("invalid id %q: must not start with . or -", id)
+	}
+	if strings.ContainsFunc(id, func(r rune) bool { return r == '/' || unicode.IsControl(r) }) {
+		return fmt.Errorf("invalid id %q: must not contain / or control characters", id)
+	}
+	return nil
+}
+
+// resolveIdConflict returns an id based on id that isn't taken according to
+// the policy, or an error if the policy is ConflictError.
+func resolveIdConflict(id, hash, policy string, taken func(string) bool) (string, error) {
+	if !taken(id) {
+		return id, nil
+	}
+	switch policy {
+	case ConflictSuffix:
+		return firstFreeSuffix(id, taken), nil
+	case ConflictHash:
+		// the hash is taken as well when adding a duplicate on purpose
+		return firstFreeSuffix(id+"-"+hash[:8], taken), nil
+	}
+	return "", fmt.Errorf("a wallpaper with the id %s already exists, aborting\n(specify a different id with --id, change the filename or use --on-conflict suffix|hash)", id)
+}
+
+// firstFreeSuffix returns id if it isn't taken, otherwise id with the first
+// free number appended.
+func firstFreeSuffix(id string, taken func(string) bool) string {
+	if !taken(id) {
+		return id
+	}
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s-%d", id, n)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// shellQuote quotes s for use as a single word in a sh command line.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_./:@%+,=", r)))
+	}) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ImportIssue is a file that was skipped or failed during an import.
+type ImportIssue struct {
+	Path   string
+	Reason error
+}
+
+type ImportOptions struct {
+	AddOptions
+	// Descend into subdirectories of directories being imported
+	Recursive bool
+	// Used to download URLs being imported
+	Downloader *Downloader
+	// Read when importing "-"
+	Stdin io.Reader
+	// Maximum size of an image read from Stdin in bytes, 0 for no limit
+	StdinMaxSize int64
+}
+
+type ImportResult struct {
+	Added []*Wallpaper
+	// Files that aren't images, are already in the store or were rejected by
+	// import.validate
+	Skipped []ImportIssue
+	Failed  []ImportIssue
+}
+
+// ImportPaths adds the images at the given paths to the store. Paths may be
+// files, directories (whose files are imported, descending into
+// subdirectories if opts.Recursive is set), glob patterns, HTTP(S) URLs or
+// "-" for opts.Stdin. Image headers are decoded in parallel and URLs are
+// downloaded before the store is locked, so only adding the images holds the
+// lock; nothing is precached. The error is about updating the store, issues
+// with individual files are recorded in the result.
+func (w *Walls) ImportPaths(ctx context.Context, paths []string, opts ImportOptions) (*ImportResult, error) {
+	result := &ImportResult{}
+
+	var urls []string
+	stdin := false
+	paths = slices.DeleteFunc(slices.Clone(paths), func(p string) bool {
+		switch {
+		case p == "-":
+			stdin = true
+		case isURL(p):
+			urls = append(urls, p)
+		default:
+			return false
+		}
+		return true
+	})
+
+	files := expandImportPaths(paths, opts.Recursive, result)
+	if opts.Id != "" && len(files)+len(urls)+btoi(stdin) > 1 {
+		result.Failed = append(result.Failed, ImportIssue{
+			Path:   strings.Join(paths, " "),
+			Reason: fmt.Errorf("--id can only be used when adding a single file"),
+		})
+		return result, nil
+	}
+
+	infos := make([]*imageInfo, len(files))
+	errs := make([]error, len(files))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for i, path := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			logger.Debugf("probing %s", path)
+			infos[i], errs[i] = probeImageFile(path)
+		}()
+	}
+	wg.Wait()
+
+	var pending []*pendingImport
+	for i, path := range files {
+		if err := errs[i]; err != nil {
+			result.add(path, nil, err)
+			continue
+		}
+		pending = append(pending, &pendingImport{name: path, path: path, info: infos[i], opts: opts.AddOptions})
+	}
+
+	if stdin {
+		if opts.Stdin == nil {
+			result.Failed = append(result.Failed, ImportIssue{"-", fmt.Errorf("reading from stdin is not supported here")})
+		} else if data, err := readImageData(opts.Stdin, opts.StdinMaxSize); err != nil {
+			result.add("stdin", nil, err)
+		} else {
+			pending = append(pending, &pendingImport{name: "stdin", data: data, opts: opts.AddOptions})
+		}
+	}
+
+	for _, u := range urls {
+		p, err := fetchURL(ctx, u, opts)
+		if err != nil {
+			result.add(u, nil, err)
+			continue
+		}
+		defer os.Remove(p.path)
+		pending = append(pending, p)
+	}
+
+	if len(pending) == 0 {
+		return result, nil
+	}
+	// add sequentially so ids and duplicates are checked against earlier files
+	err := w.UpdateStore(ctx, func() bool {
+		for _, p := range pending {
+			var wp *Wallpaper
+			var err error
+			if p.data != nil {
+				wp, err = w.addData(ctx, p.data, "", p.opts)
+			} else {
+				wp, err = w.addProbed(ctx, p.path, p.info, p.opts)
+			}
+			result.add(p.name, wp, err)
+		}
+		return len(result.Added) > 0
+	})
+	return result, err
+}
+
+// pendingImport is an image that has been probed, read or downloaded and is
+// ready to be added to the store.
+type pendingImport struct {
+	// Path, URL or "stdin", for reporting
+	name string
+	// File to add, if the image isn't held in data
+	path string
+	info *imageInfo
+	data []byte
+	opts AddOptions
+}
+
+// readImageData reads an image, such as stdin, into memory, failing with
+// ErrTooLarge if it is larger than maxSize bytes (unless maxSize is 0).
+func readImageData(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize > 0 {
+		// read one byte past the limit to detect oversized images
+		r = io.LimitReader(r, maxSize+1)
+	}
+	// image.DecodeConfig consumes the header, so buffer everything to be able
+	// to read the image again for hashing and writing
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading image: %w", err)
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w (limit %d bytes)", ErrTooLarge, maxSize)
+	}
+	return data, nil
+}
+
+// add records the outcome of adding the file at path.
+func (r *ImportResult) add(path string, wp *Wallpaper, err error) {
+	var dup *DuplicateError
+	var invalid *ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		r.Skipped = append(r.Skipped, ImportIssue{path, err})
+	case errors.As(err, &dup):
+		r.Skipped = append(r.Skipped, ImportIssue{path, fmt.Errorf("identical to existing wallpaper %s", dup.Id)})
+	case errors.Is(err, image.ErrFormat):
+		r.Skipped = append(r.Skipped, ImportIssue{path, fmt.Errorf("not a supported image")})
+	case err != nil:
+		r.Failed = append(r.Failed, ImportIssue{path, err})
+	default:
+		logger.Debugf("added %s as %s", path, wp.Id)
+		r.Added = append(r.Added, wp)
+	}
+}
+
+// fetchURL downloads an image to be added to the store, recording the URL as
+// its source. The caller removes the download once it has been added.
+func fetchURL(ctx context.Context, rawURL string, opts ImportOptions) (*pendingImport, error) {
+	if opts.Downloader == nil {
+		return nil, fmt.Errorf("downloading is not supported here")
+	}
+	logger.Infof("downloading %s", rawURL)
+	dl, err := opts.Downloader.Download(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// the type is taken from the content, not the url or the server
+	info, err := probeImageFile(dl.Path)
+	if err != nil {
+		os.Remove(dl.Path)
+		return nil, err
+	}
+	addOpts := opts.AddOptions
+	addOpts.Filename = dl.Filename
+	addOpts.Source = rawURL
+	// the download is removed afterwards, so it can only be copied
+	addOpts.Mode = ImportCopy
+	return &pendingImport{name: rawURL, path: dl.Path, info: info, opts: addOpts}, nil
+}
+
+// expandImportPaths resolves import arguments to a list of files. Paths that
+// can't be resolved are recorded as failed in result.
+func expandImportPaths(paths []string, recursive bool, result *ImportResult) []string {
+	var files []string
+	seen := make(map[string]bool)
+	addFile := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range paths {
+		matches := []string{arg}
+		if _, err := os.Stat(arg); err != nil && strings.ContainsAny(arg, "*?[") {
+			// not an existing file, expand as a glob
+			globbed, err := filepath.Glob(arg)
+			if err != nil {
+				result.Failed = append(result.Failed, ImportIssue{arg, fmt.Errorf("invalid pattern: %w", err)})
+				continue
+			}
+			if len(globbed) == 0 {
+				result.Failed = append(result.Failed, ImportIssue{arg, fmt.Errorf("no files match")})
+				continue
+			}
+			matches = globbed
+		}
+
+		for _, path := range matches {
+			stat, err := os.Stat(path)
+			if err != nil {
+				result.Failed = append(result.Failed, ImportIssue{path, err})
+				continue
+			}
+			if !stat.IsDir() {
+				addFile(path)
+				continue
+			}
+
+			err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					result.Failed = append(result.Failed, ImportIssue{p, err})
+					return nil
+				}
+				if d.IsDir() {
+					if p != path && (!recursive || strings.HasPrefix(d.Name(), ".")) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if strings.HasPrefix(d.Name(), ".") || !(d.Type().IsRegular() || d.Type()&fs.ModeSymlink != 0) {
+					return nil
+				}
+				addFile(p)
+				return nil
+			})
+			if err != nil {
+				result.Failed = append(result.Failed, ImportIssue{path, err})
+			}
+		}
+	}
+
+	return files
+}
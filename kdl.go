@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -21,16 +22,19 @@ func (w *Walls) WriteStore(ctx context.Context) error {
 		return fmt.Errorf("marshalling store: %w", err)
 	}
 
-	f, err := os.Create(storePath)
-	if err != nil {
-		return fmt.Errorf("opening store file: %w", err)
-	}
-	defer f.Close()
-	err = kdl.Emit(doc, f)
+	var buf bytes.Buffer
+	err = kdl.Emit(doc, &buf)
 	if err != nil {
 		return fmt.Errorf("emitting store file %s: %w", storePath, err)
 	}
 
+	if err := backupFile(storePath); err != nil {
+		return fmt.Errorf("backing up store file: %w", err)
+	}
+	if err := writeFileAtomic(storePath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing store file %s: %w", storePath, err)
+	}
+
 	return nil
 }
 
@@ -41,7 +45,7 @@ func (w *Walls) LoadStore(ctx context.Context) error {
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			// no store file, that's okay - nothing to load
-			w.Store = &Store{}
+			w.Store = &Store{Version: storeVersion}
 			return nil
 		}
 		return fmt.Errorf("opening store file: %w", err)
@@ -53,10 +57,16 @@ func (w *Walls) LoadStore(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("parsing store file %s: %w", storePath, err)
 	}
+	if store.Version > storeVersion {
+		return fmt.Errorf("store file %s has version %d, but this version of walls only supports up to version %d", storePath, store.Version, storeVersion)
+	}
 	w.Store = &store
 
-	logger.Debugf("store loaded, %d wallpapers defined", len(w.Store.Wallpapers))
+	logger.Debugf("store version %d loaded, %d wallpapers defined", w.Store.Version, len(w.Store.Wallpapers))
 
+	if w.migrate && w.Store.Version != storeVersion {
+		return w.migrateLoadedStore(ctx)
+	}
 	return nil
 }
 
@@ -96,8 +106,16 @@ func (wp *Wallpaper) MarshalKDL() (*kdl.Node, error) {
 		AddChildren(
 			kdl.NewKV("path", wp.Path),
 			kdl.NewKV("original", wp.OriginalFilename),
+			kdl.NewKV("original-dir", wp.OriginalDir),
 			kdl.NewKV("resolution", wp.Resolution.String()),
 			kdl.NewKV("type", wp.MimeType),
+			kdl.NewKV("hash", wp.Hash),
+			kdl.NewKV("weight", wp.Weight),
+			kdl.NewKV("rating", wp.Rating),
+			kdl.NewKV("favorite", wp.Favorite),
+			kdl.NewKV("source", wp.Source),
+			kdl.NewKV("mode", wp.ImportMode()),
+			kdl.NewKV("orientation", wp.Orientation),
 			kdl.NewKV("enabled", wp.Enabled),
 			tagsNode,
 		)
@@ -106,6 +124,7 @@ func (wp *Wallpaper) MarshalKDL() (*kdl.Node, error) {
 
 func (s *Store) MarshalKDL() (*kdl.Document, error) {
 	doc := kdl.NewDocument()
+	doc.AddNodes(kdl.NewKV("version", s.Version))
 
 	n, err := kdl.MarshalAll(s.Wallpapers)
 	if err != nil {
@@ -115,3 +134,18 @@ func (s *Store) MarshalKDL() (*kdl.Document, error) {
 
 	return doc, nil
 }
+
+var _ kdl.ValueUnmarshaler = (*EffectParam)(nil)
+
+func (p *EffectParam) UnmarshalKDL(v kdl.Value) error {
+	if v.Kind() == kdl.String || v.Kind() == kdl.Bool {
+		return fmt.Errorf("invalid number %s", v)
+	}
+	value, err := strconv.ParseFloat(v.String(), 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", v)
+	}
+	p.Value = value
+	p.Set = true
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// How a command locks the store.
+type lockMode int
+
+const (
+	// Exclusive lock held until the process exits, covering the whole
+	// load-modify-sync cycle
+	lockExclusive lockMode = iota
+	// Shared lock held until the process exits, for commands that only read
+	lockShared
+	// Shared lock while loading only; the command changes the store through
+	// UpdateStore, so other commands can run during its long-running work
+	lockLoad
+)
+
+// commandLocks holds the lock modes of commands that don't need an exclusive
+// lock for their whole run.
+var commandLocks = map[string]lockMode{
+	"current":  lockShared,
+	"list":     lockShared,
+	"history":  lockShared,
+	"stats":    lockShared,
+	"add":      lockLoad,
+	"precache": lockLoad,
+	"watch":    lockLoad,
+}
+
+// Lock takes an exclusive advisory lock on the store, waiting for any other
+// walls process holding it to finish. A shared lock held by this process is
+// upgraded. The lock is released by Unlock or when the process exits.
+func (w *Walls) Lock(ctx context.Context) error {
+	return w.lock(syscall.LOCK_EX)
+}
+
+// LockShared takes a shared advisory lock on the store, which other readers
+// may hold at the same time, waiting for any writer to finish. An exclusive
+// lock held by this process is downgraded.
+func (w *Walls) LockShared(ctx context.Context) error {
+	return w.lock(syscall.LOCK_SH)
+}
+
+func (w *Walls) lock(how int) error {
+	if w.lockFile != nil && w.lockHow == how {
+		return nil
+	}
+
+	lockPath := filepath.Join(w.Config.Storage.Runtime, "walls.lock")
+	f := w.lockFile
+	if f == nil {
+		var err error
+		f, err = os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("opening lock file %s: %w", lockPath, err)
+		}
+	}
+
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		logger.Debugf("store is locked by another process, waiting")
+		err = syscall.Flock(int(f.Fd()), how)
+	}
+	if err != nil {
+		if w.lockFile == nil {
+			f.Close()
+		}
+		return fmt.Errorf("locking %s: %w", lockPath, err)
+	}
+
+	if how == syscall.LOCK_EX {
+		logger.Debugf("acquired exclusive lock %s", lockPath)
+	} else {
+		logger.Debugf("acquired shared lock %s", lockPath)
+	}
+	w.lockFile = f
+	w.lockHow = how
+	return nil
+}
+
+// Unlock releases the lock taken by Lock or LockShared.
+func (w *Walls) Unlock() {
+	if w.lockFile == nil {
+		return
+	}
+	logger.Debugf("releasing lock %s", w.lockFile.Name())
+	// closing the file releases the lock
+	w.lockFile.Close()
+	w.lockFile = nil
+	w.lockHow = 0
+}
+
+// UpdateStore reloads the store and runs fn with the store locked
+// exclusively, writing the store afterwards if fn reports a change. A lock
+// held before is restored once the store is written.
+func (w *Walls) UpdateStore(ctx context.Context, fn func() bool) error {
+	prev := w.lockHow
+	if prev != syscall.LOCK_EX {
+		if err := w.Lock(ctx); err != nil {
+			return fmt.Errorf("locking store: %w", err)
+		}
+		defer func() {
+			if prev == syscall.LOCK_SH {
+				if err := w.LockShared(ctx); err != nil {
+					logger.Errorf("downgrading store lock: %w", err)
+				}
+			} else {
+				w.Unlock()
+			}
+		}()
+		// another process may have changed the store since it was loaded
+		if err := w.LoadStore(ctx); err != nil {
+			return fmt.Errorf("loading store: %w", err)
+		}
+	}
+
+	if !fn() {
+		return nil
+	}
+	if err := w.WriteStore(ctx); err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"syscall"
+)
+
+// storeVersion is the version of the store layout written by this version of
+// walls. Bump it and add a migration whenever the layout changes.
+const storeVersion = 7
+
+type migration struct {
+	// The version this migration upgrades from; it upgrades to From+1
+	From        int
+	Description string
+	// Upgrades the store in place, returning a description of each change made
+	Migrate func(ctx context.Context, w *Walls, s *Store) ([]string, error)
+}
+
+// migrations must be ordered by From, with one migration per version.
+var migrations = []migration{
+	{
+		From:        0,
+		Description: "record content hashes of wallpapers",
+		Migrate:     migrateHashes,
+	},
+	{
+		From:        1,
+		Description: "add wallpaper weights",
+		Migrate:     noChanges,
+	},
+	{
+		From:        2,
+		Description: "add ratings and favorites",
+		Migrate:     noChanges,
+	},
+	{
+		From:        3,
+		Description: "record the urls wallpapers were downloaded from",
+		Migrate:     noChanges,
+	},
+	{
+		From:        4,
+		Description: "add import modes; referenced wallpapers' paths point outside the store",
+		Migrate:     noChanges,
+	},
+	{
+		From:        5,
+		Description: "record the directories wallpapers were added from",
+		Migrate:     noChanges,
+	},
+	{
+		From:        6,
+		Description: "record exif orientations; resolutions are those of the upright image",
+		Migrate:     migrateOrientations,
+	},
+}
+
+type MigrationStep struct {
+	From        int
+	To          int
+	Description string
+	Changes     []string
+}
+
+// MigrateStore upgrades the loaded store to the current store version, one
+// version at a time. Unless dryRun is set, the original store file is backed
+// up and the upgraded store is written back to disk.
+func (w *Walls) MigrateStore(ctx context.Context, dryRun bool) ([]*MigrationStep, error) {
+	from := w.Store.Version
+	if from == storeVersion {
+		return nil, nil
+	}
+
+	store := w.Store
+	if dryRun {
+		store = store.Clone()
+	} else {
+		storePath := filepath.Join(w.Config.Storage.Sources, "store.kdl")
+		backupPath := fmt.Sprintf("%s.v%d.bak", storePath, from)
+		logger.Infof("migrating store from version %d to %d, backing up original to %s", from, storeVersion, backupPath)
+		if err := copyFile(storePath, backupPath); err != nil {
+			return nil, fmt.Errorf("backing up store: %w", err)
+		}
+	}
+
+	var steps []*MigrationStep
+	for _, m := range migrations {
+		if m.From < store.Version {
+			continue
+		}
+		if m.From != store.Version {
+			return steps, fmt.Errorf("no migration from store version %d", store.Version)
+		}
+		logger.Debugf("migrating store from version %d: %s", m.From, m.Description)
+		changes, err := m.Migrate(ctx, w, store)
+		if err != nil {
+			return steps, fmt.Errorf("migrating store from version %d: %w", m.From, err)
+		}
+		store.Version = m.From + 1
+		steps = append(steps, &MigrationStep{
+			From:        m.From,
+			To:          store.Version,
+			Description: m.Description,
+			Changes:     changes,
+		})
+	}
+
+	if dryRun {
+		return steps, nil
+	}
+	if err := w.WriteStore(ctx); err != nil {
+		return steps, fmt.Errorf("writing migrated store: %w", err)
+	}
+	return steps, nil
+}
+
+// migrateLoadedStore upgrades the store that has just been loaded. Migrating
+// writes the store, which needs the exclusive lock; without it, the lock is
+// taken by UpdateStore, whose reload under the lock migrates the store.
+func (w *Walls) migrateLoadedStore(ctx context.Context) error {
+	if w.lockHow == syscall.LOCK_EX {
+		_, err := w.MigrateStore(ctx, false)
+		return err
+	}
+	return w.UpdateStore(ctx, func() bool { return false })
+}
+
+// noChanges is the migration of versions that only add fields, which older
+// versions of walls would drop or misinterpret. Bumping the version makes
+// them refuse the store instead.
+func noChanges(ctx context.Context, w *Walls, s *Store) ([]string, error) {
+	return nil, nil
+}
+
+func migrateHashes(ctx context.Context, w *Walls, s *Store) ([]string, error) {
+	var changes []string
+	for _, wp := range s.Wallpapers {
+		if wp.Hash != "" {
+			continue
+		}
+		hash, err := hashFile(wp.Path)
+		if err != nil {
+			// not fatal, the hash will be filled in once the file is fixed
+			changes = append(changes, fmt.Sprintf("wallpaper %s: cannot hash source (%s), skipped", wp.Id, err))
+			continue
+		}
+		wp.Hash = hash
+		changes = append(changes, fmt.Sprintf("wallpaper %s: set hash to %s", wp.Id, hash))
+	}
+	return changes, nil
+}
+
+func migrateOrientations(ctx context.Context, w *Walls, s *Store) ([]string, error) {
+	var changes []string
+	for _, wp := range s.Wallpapers {
+		info, err := probeImageFile(wp.Path)
+		if err != nil {
+			// not fatal, the file is displayed as before
+			changes = append(changes, fmt.Sprintf("wallpaper %s: cannot read source (%s), skipped", wp.Id, err))
+			continue
+		}
+		if info.Orientation == 0 {
+			continue
+		}
+		wp.Orientation = info.Orientation
+		wp.Resolution = info.Resolution
+		changes = append(changes, fmt.Sprintf("wallpaper %s: set orientation to %d and resolution to %s", wp.Id, info.Orientation, info.Resolution))
+	}
+	return changes, nil
+}
+
+// Clone returns a deep copy of the store.
+func (s *Store) Clone() *Store {
+	clone := *s
+	clone.Wallpapers = make([]*Wallpaper, len(s.Wallpapers))
+	for i, wp := range s.Wallpapers {
+		c := *wp
+		c.Tags = maps.Clone(wp.Tags)
+		clone.Wallpapers[i] = &c
+	}
+	return &clone
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ImportRule tags wallpapers matching all of its conditions when they are
+// added or retagged. Conditions that are empty always match.
+type ImportRule struct {
+	// Directory the file was added from, including subdirectories
+	Dir string `kdl:"dir"`
+	// Regular expression matched against the original filename; its capture
+	// groups can be used in tags as $1 or ${name}
+	Filename string `kdl:"filename"`
+	// Resolution or aspect ratio class, see resolutionClasses
+	Class string `kdl:"class"`
+	// MIME type, may be a glob such as image/*
+	Type string `kdl:"type"`
+	// Tags to add, as key or key=value
+	Tags []string `kdl:",arguments"`
+
+	filename *regexp.Regexp
+}
+
+// Resolution classes, from the aspect ratio and from the size of the image.
+// Size classes are cumulative, so a 4k image is also qhd and hd.
+var (
+	aspectClasses = []struct {
+		name     string
+		min, max float64
+	}{
+		{"portrait", 0, 0.95},
+		{"square", 0.95, 1.05},
+		{"standard", 1.05, 1.7},
+		{"wide", 1.7, 2.1},
+		{"ultrawide", 2.1, 0},
+	}
+	sizeClasses = []struct {
+		name        string
+		long, short int
+	}{
+		{"hd", 1920, 1080},
+		{"qhd", 2560, 1440},
+		{"4k", 3840, 2160},
+		{"5k", 5120, 2880},
+		{"8k", 7680, 4320},
+	}
+)
+
+func classNames() []string {
+	var names []string
+	for _, c := range aspectClasses {
+		names = append(names, c.name)
+	}
+	for _, c := range sizeClasses {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// resolutionClasses returns the classes a resolution belongs to.
+func resolutionClasses(res Resolution) []string {
+	var classes []string
+	if res.Height > 0 {
+		ratio := float64(res.Width) / float64(res.Height)
+		for _, c := range aspectClasses {
+			if ratio >= c.min && (c.max == 0 || ratio < c.max) {
+				classes = append(classes, c.name)
+			}
+		}
+	}
+	long, short := max(res.Width, res.Height), min(res.Width, res.Height)
+	for _, c := range sizeClasses {
+		if long >= c.long && short >= c.short {
+			classes = append(classes, c.name)
+		}
+	}
+	return classes
+}
+
+// compile validates the rule and prepares it for matching.
+func (r *ImportRule) compile() error {
+	if len(r.Tags) == 0 {
+		return fmt.Errorf("no tags given")
+	}
+	for _, tag := range r.Tags {
+		if _, _, _, err := parseTag(tag); err != nil {
+			return err
+		}
+	}
+	if r.Dir != "" {
+		dir, err := filepath.Abs(expandPath(r.Dir))
+		if err != nil {
+			return fmt.Errorf("dir: %w", err)
+		}
+		r.Dir = dir
+	}
+	if r.Filename != "" {
+		re, err := regexp.Compile(r.Filename)
+		if err != nil {
+			return fmt.Errorf("filename: %w", err)
+		}
+		r.filename = re
+	}
+	if r.Class != "" && !slices.Contains(classNames(), r.Class) {
+		return fmt.Errorf("class: unknown class %s (available: %s)", r.Class, strings.Join(classNames(), ", "))
+	}
+	if r.Type != "" {
+		if _, err := path.Match(r.Type, ""); err != nil {
+			return fmt.Errorf("type: %w", err)
+		}
+	}
+	return nil
+}
+
+// match returns the tags the rule gives wp, or false if it doesn't match.
+func (r *ImportRule) match(wp *Wallpaper) (map[string]string, bool) {
+	if r.Dir != "" {
+		dir := wp.SourceDir()
+		if dir == "" || (dir != r.Dir && !strings.HasPrefix(dir, r.Dir+string(filepath.Separator))) {
+			return nil, false
+		}
+	}
+	if r.Class != "" && !slices.Contains(resolutionClasses(wp.Resolution), r.Class) {
+		return nil, false
+	}
+	if r.Type != "" {
+		if ok, _ := path.Match(r.Type, wp.MimeType); !ok {
+			return nil, false
+		}
+	}
+	var submatches []int
+	if r.filename != nil {
+		submatches = r.filename.FindStringSubmatchIndex(wp.OriginalFilename)
+		if submatches == nil {
+			return nil, false
+		}
+	}
+
+	tags := make(map[string]string, len(r.Tags))
+	for _, tag := range r.Tags {
+		if r.filename != nil {
+			tag = string(r.filename.ExpandString(nil, tag, wp.OriginalFilename, submatches))
+		}
+		key, value, _, err := parseTag(tag)
+		if err != nil {
+			logger.Warnf("import rule: %s", err)
+			continue
+		}
+		tags[key] = value
+	}
+	return tags, true
+}
+
+// SourceDir returns the absolute directory the wallpaper was added from, or
+// "" if it is unknown.
+func (wp *Wallpaper) SourceDir() string {
+	if wp.OriginalDir != "" {
+		return wp.OriginalDir
+	}
+	if wp.ImportMode() == ImportReference {
+		return filepath.Dir(wp.Path)
+	}
+	return ""
+}
+
+// RuleTags returns the tags the configured import rules give wp. Later rules
+// override values set by earlier ones.
+func (w *Walls) RuleTags(wp *Wallpaper) map[string]string {
+	tags := make(map[string]string)
+	for i := range w.Config.Import.Rules {
+		if ruleTags, ok := w.Config.Import.Rules[i].match(wp); ok {
+			maps.Copy(tags, ruleTags)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State records the current wallpaper. It lives in the runtime directory, so
+// it is reset on reboot.
+type State struct {
+	// Id of the current wallpaper
+	Id string `json:"id"`
+	// Effect applied by the first set command, empty if none
+	Effect string `json:"effect"`
+	// Path of the image passed to the first set command
+	Path string `json:"path"`
+	// When the wallpaper was set
+	SetAt time.Time `json:"set_at"`
+	// PIDs of the processes started by the set commands
+	Pids []int `json:"pids"`
+}
+
+func (w *Walls) statePath() string {
+	return filepath.Join(w.Config.Storage.Runtime, "state.json")
+}
+
+// State returns the recorded state, or nil if no wallpaper has been set. The
+// state is read from disk on first use.
+func (w *Walls) State(ctx context.Context) (*State, error) {
+	if w.state != nil {
+		return w.state, nil
+	}
+
+	data, err := os.ReadFile(w.statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", w.statePath(), err)
+	}
+	w.state = &state
+	return w.state, nil
+}
+
+// WriteState records state as the current state.
+func (w *Walls) WriteState(ctx context.Context, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling state: %w", err)
+	}
+	logger.Debugf("writing state to %s", w.statePath())
+	if err := writeFileAtomic(w.statePath(), data, 0644); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	w.state = state
+	return nil
+}
+
+// CurrentId returns the id of the current wallpaper, or "" if unknown.
+func (w *Walls) CurrentId(ctx context.Context) string {
+	state, err := w.State(ctx)
+	if err != nil {
+		logger.Warnf("%s", err)
+		return ""
+	}
+	if state == nil {
+		return ""
+	}
+	return state.Id
+}
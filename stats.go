@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type WallpaperStats struct {
+	// Number of times the wallpaper was set
+	Count    int       `json:"count"`
+	FirstSet time.Time `json:"first_set"`
+	LastSet  time.Time `json:"last_set"`
+	// Approximate time the wallpaper was on screen, measured from when it was
+	// set until the next wallpaper was set
+	ShownSeconds int64 `json:"shown_seconds"`
+}
+
+// Stats holds usage statistics per wallpaper id.
+type Stats struct {
+	Wallpapers map[string]*WallpaperStats `json:"wallpapers"`
+}
+
+func (w *Walls) statsPath() string {
+	return filepath.Join(w.Config.Storage.Sources, "stats.json")
+}
+
+// LoadStats reads the usage statistics from the data directory.
+func (w *Walls) LoadStats(ctx context.Context) (*Stats, error) {
+	stats := &Stats{Wallpapers: make(map[string]*WallpaperStats)}
+	data, err := os.ReadFile(w.statsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading stats file: %w", err)
+	}
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, fmt.Errorf("parsing stats file %s: %w", w.statsPath(), err)
+	}
+	if stats.Wallpapers == nil {
+		stats.Wallpapers = make(map[string]*WallpaperStats)
+	}
+	return stats, nil
+}
+
+// WriteStats writes the usage statistics to the data directory.
+func (w *Walls) WriteStats(ctx context.Context, stats *Stats) error {
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling stats: %w", err)
+	}
+	if err := writeFileAtomic(w.statsPath(), data, 0644); err != nil {
+		return fmt.Errorf("writing stats file: %w", err)
+	}
+	return nil
+}
+
+// RecordStats counts the wallpaper in state as set, and credits the previous
+// wallpaper (if known) with the time it was shown.
+func (w *Walls) RecordStats(ctx context.Context, prev *State, state *State) error {
+	stats, err := w.LoadStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	if prev != nil && state.SetAt.After(prev.SetAt) {
+		if s, ok := stats.Wallpapers[prev.Id]; ok {
+			s.ShownSeconds += int64(state.SetAt.Sub(prev.SetAt).Seconds())
+		}
+	}
+
+	s, ok := stats.Wallpapers[state.Id]
+	if !ok {
+		s = &WallpaperStats{FirstSet: state.SetAt}
+		stats.Wallpapers[state.Id] = s
+	}
+	s.Count++
+	s.LastSet = state.SetAt
+
+	return w.WriteStats(ctx, stats)
+}
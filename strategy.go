@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Strategy decides which wallpaper walls set picks when no wallpaper is given.
+type Strategy interface {
+	// Pick chooses one of candidates, which is never empty.
+	Pick(ctx context.Context, w *Walls, candidates []*Wallpaper) (*Wallpaper, error)
+}
+
+// strategies maps the names accepted by behavior.strategy and --strategy to
+// their implementations.
+var strategies = map[string]Strategy{
+	"random":        randomStrategy{},
+	"shuffle":       shuffleStrategy{},
+	"weighted":      weightedStrategy{},
+	"lru":           lruStrategy{},
+	"sequential":    sequentialStrategy{byId: false},
+	"sequential-id": sequentialStrategy{byId: true},
+}
+
+func strategyNames() string {
+	return strings.Join(slices.Sorted(maps.Keys(strategies)), ", ")
+}
+
+// PickWallpaper picks an enabled wallpaper using the named strategy, or the
+// configured strategy if name is empty. If match is not nil, only wallpapers
+// it returns true for are considered. It returns nil if there is nothing to
+// pick from.
+func (w *Walls) PickWallpaper(ctx context.Context, name string, match func(*Wallpaper) bool) (*Wallpaper, error) {
+	if name == "" {
+		name = w.Config.Behavior.Strategy
+	}
+	strategy, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %s (available: %s)", name, strategyNames())
+	}
+
+	candidates := make([]*Wallpaper, 0, len(w.Store.Wallpapers))
+	for _, wp := range w.Store.Wallpapers {
+		if wp.Enabled && (match == nil || match(wp)) {
+			candidates = append(candidates, wp)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	if !w.Config.Behavior.AllowRepeat && len(candidates) > 1 {
+		current := w.CurrentId(ctx)
+		candidates = slices.DeleteFunc(candidates, func(wp *Wallpaper) bool {
+			return wp.Id == current
+		})
+	}
+
+	logger.Debugf("picking from %d wallpapers with strategy %s", len(candidates), name)
+	return strategy.Pick(ctx, w, candidates)
+}
+
+// ratingFactor is how much more likely the wallpaper is to be picked at random
+// because of its rating and favorite status, according to behavior.rating-bias.
+// Unrated wallpapers count as rated 3; favorites get one extra star.
+func (w *Walls) ratingFactor(wp *Wallpaper) float64 {
+	stars := float64(wp.Rating)
+	if wp.Rating == 0 {
+		stars = 3
+	}
+	if wp.Favorite {
+		stars++
+	}
+	return math.Exp(w.Config.Behavior.RatingBias * (stars - 3))
+}
+
+// pickWeighted picks at random, with each candidate's chance proportional to
+// its weight.
+func pickWeighted(candidates []*Wallpaper, weight func(*Wallpaper) float64) *Wallpaper {
+	total := 0.0
+	for _, wp := range candidates {
+		total += weight(wp)
+	}
+	n := rand.Float64() * total
+	for _, wp := range candidates {
+		n -= weight(wp)
+		if n < 0 {
+			return wp
+		}
+	}
+	// only reachable through rounding errors
+	return candidates[len(candidates)-1]
+}
+
+// randomStrategy picks at random, uniformly unless ratings are biased.
+type randomStrategy struct{}
+
+func (randomStrategy) Pick(ctx context.Context, w *Walls, candidates []*Wallpaper) (*Wallpaper, error) {
+	if w.Config.Behavior.RatingBias == 0 {
+		return candidates[rand.IntN(len(candidates))], nil
+	}
+	return pickWeighted(candidates, w.ratingFactor), nil
+}
+
+// shuffleStrategy goes through all wallpapers in a random order before
+// repeating any, see ShuffleBag.
+type shuffleStrategy struct{}
+
+func (shuffleStrategy) Pick(ctx context.Context, w *Walls, candidates []*Wallpaper) (*Wallpaper, error) {
+	return w.shufflePick(ctx, candidates)
+}
+
+// weightedStrategy picks at random, with each wallpaper's chance proportional
+// to its weight (and rating, see ratingFactor).
+type weightedStrategy struct{}
+
+func (weightedStrategy) Pick(ctx context.Context, w *Walls, candidates []*Wallpaper) (*Wallpaper, error) {
+	return pickWeighted(candidates, func(wp *Wallpaper) float64 {
+		return wp.EffectiveWeight() * w.ratingFactor(wp)
+	}), nil
+}
+
+// lruStrategy picks the wallpaper that was shown least recently, preferring
+// wallpapers that were never shown. Ties are broken randomly.
+type lruStrategy struct{}
+
+func (lruStrategy) Pick(ctx context.Context, w *Walls, candidates []*Wallpaper) (*Wallpaper, error) {
+	stats, err := w.LoadStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lastShown := make(map[string]time.Time, len(stats.Wallpapers))
+	for id, s := range stats.Wallpapers {
+		lastShown[id] = s.LastSet
+	}
+
+	candidates = slices.Clone(candidates)
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	return slices.MinFunc(candidates, func(a, b *Wallpaper) int {
+		return lastShown[a.Id].Compare(lastShown[b.Id])
+	}), nil
+}
+
+// sequentialStrategy picks the wallpaper following the current one, in the
+// order wallpapers were added or by id, wrapping around at the end.
+type sequentialStrategy struct {
+	byId bool
+}
+
+func (s sequentialStrategy) Pick(ctx context.Context, w *Walls, candidates []*Wallpaper) (*Wallpaper, error) {
+	// the store keeps wallpapers in the order they were added
+	order := slices.Clone(w.Store.Wallpapers)
+	if s.byId {
+		slices.SortFunc(order, func(a, b *Wallpaper) int {
+			return cmp.Compare(a.Id, b.Id)
+		})
+	}
+
+	current := w.CurrentId(ctx)
+	start := slices.IndexFunc(order, func(wp *Wallpaper) bool {
+		return wp.Id == current
+	})
+
+	isCandidate := make(map[*Wallpaper]bool, len(candidates))
+	for _, wp := range candidates {
+		isCandidate[wp] = true
+	}
+	for i := 1; i <= len(order); i++ {
+		wp := order[(start+i)%len(order)]
+		if isCandidate[wp] {
+			return wp, nil
+		}
+	}
+	return candidates[0], nil
+}
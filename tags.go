@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// parseTag splits a tag argument of the form key or key=value.
+func parseTag(s string) (key, value string, hasValue bool, err error) {
+	key, value, hasValue = strings.Cut(s, "=")
+	if key == "" {
+		return "", "", false, fmt.Errorf("invalid tag %q: missing key", s)
+	}
+	return key, value, hasValue, nil
+}
+
+// HasTag reports whether the wallpaper has the tag key. If hasValue is set,
+// the tag must also have the given value.
+func (wp *Wallpaper) HasTag(key, value string, hasValue bool) bool {
+	v, ok := wp.Tags[key]
+	if !ok {
+		return false
+	}
+	return !hasValue || v == value
+}
+
+// tagMatcher returns a function matching wallpapers that have all of the given
+// tags (key or key=value). It returns nil if tags is empty.
+func tagMatcher(tags []string) (func(*Wallpaper) bool, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	type query struct {
+		key, value string
+		hasValue   bool
+	}
+	queries := make([]query, len(tags))
+	for i, tag := range tags {
+		key, value, hasValue, err := parseTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		queries[i] = query{key, value, hasValue}
+	}
+	return func(wp *Wallpaper) bool {
+		for _, q := range queries {
+			if !wp.HasTag(q.key, q.value, q.hasValue) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// formatTag formats a tag as key, or key=value if it has a value.
+func formatTag(key, value string) string {
+	if value == "" {
+		return key
+	}
+	return key + "=" + value
+}
+
+// formatTags formats tags as a sorted, comma-separated list of key or
+// key=value.
+func formatTags(tags map[string]string) string {
+	parts := make([]string, 0, len(tags))
+	for key, value := range tags {
+		parts = append(parts, formatTag(key, value))
+	}
+	slices.Sort(parts)
+	return strings.Join(parts, ", ")
+}
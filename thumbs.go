@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// Cache subdirectory holding thumbnails. Thumbnails of source files are
+// named thumbs/<id>.jpg, those of effect outputs thumbs/<effect>/<id>.jpg.
+const thumbsCacheDir = "thumbs"
+
+// Size of the long edge of thumbnails in pixels
+const thumbnailSize = 256
+
+// imageSlots limits how many full-size images are decoded at the same time
+// across all wallpapers, since decoding large images takes a lot of memory.
+var imageSlots = make(chan struct{}, runtime.NumCPU())
+
+// acquireImageSlot waits until a full-size image may be decoded, returning a
+// function that releases the slot.
+func acquireImageSlot() func() {
+	imageSlots <- struct{}{}
+	return func() { <-imageSlots }
+}
+
+// Thumbnails holds the paths of a wallpaper's thumbnails that exist.
+type Thumbnails struct {
+	Source  string            `json:"source,omitempty"`
+	Effects map[string]string `json:"effects"`
+}
+
+// ThumbnailPath returns the path of the thumbnail of the wallpaper's source
+// file, or of its output for effect if effect isn't empty.
+func (wp *Wallpaper) ThumbnailPath(ctx context.Context, effect string) string {
+	return filepath.Join(getWalls(ctx).Config.Storage.Cache, thumbsCacheDir, effect, wp.Id+".jpg")
+}
+
+// Thumbnails returns the paths of the wallpaper's existing thumbnails.
+func (w *Walls) Thumbnails(ctx context.Context, wp *Wallpaper) Thumbnails {
+	thumbs := Thumbnails{Effects: make(map[string]string)}
+	if path := wp.ThumbnailPath(ctx, ""); fileExists(path) {
+		thumbs.Source = path
+	}
+	for effect := range w.Config.Effects.Effects {
+		if path := wp.ThumbnailPath(ctx, effect); fileExists(path) {
+			thumbs.Effects[effect] = path
+		}
+	}
+	return thumbs
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// ThumbnailWallpapers generates missing or outdated thumbnails for the given
+// wallpapers in parallel, limited by imageSlots.
+func (w *Walls) ThumbnailWallpapers(ctx context.Context, wps []*Wallpaper, force bool) {
+	var wg sync.WaitGroup
+	for _, wp := range wps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.updateThumbnails(ctx, wp, force); err != nil {
+				logger.Errorf("generating thumbnails for %s: %w", wp.Id, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// updateThumbnails generates the thumbnails of the wallpaper's source file and
+// of its precached effect outputs, if they are missing or older than the file
+// they show.
+func (w *Walls) updateThumbnails(ctx context.Context, wp *Wallpaper, force bool) error {
+	if err := w.updateThumbnail(ctx, wp.Path, wp.ThumbnailPath(ctx, ""), wp.Orientation, force); err != nil {
+		return err
+	}
+	for effect := range w.Config.Effects.Effects {
+		src := wp.PathWithEffect(ctx, effect)
+		if !fileExists(src) {
+			continue
+		}
+		// the orientation of effect outputs is unknown, read it from the file
+		orientation := 0
+		if info, err := probeImageFile(src); err == nil {
+			orientation = info.Orientation
+		}
+		if err := w.updateThumbnail(ctx, src, wp.ThumbnailPath(ctx, effect), orientation, force); err != nil {
+			return fmt.Errorf("effect %s: %w", effect, err)
+		}
+	}
+	return nil
+}
+
+func (w *Walls) updateThumbnail(ctx context.Context, src, dst string, orientation int, force bool) error {
+	srcStat, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if dstStat, err := os.Stat(dst); err == nil && !force && !dstStat.ModTime().Before(srcStat.ModTime()) {
+		return nil
+	}
+
+	defer acquireImageSlot()()
+	logger.Debugf("generating thumbnail %s", dst)
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("decoding image: %w", err)
+	}
+
+	// the bounds are square, so the image can be scaled before rotating it,
+	// which is much cheaper
+	b := img.Bounds()
+	size := fitResolution(Resolution{b.Dx(), b.Dy()}, Resolution{thumbnailSize, thumbnailSize})
+	scaled := image.NewRGBA(image.Rect(0, 0, size.Width, size.Height))
+	draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, b, draw.Src, nil)
+	thumb := applyOrientation(scaled, orientation)
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("creating thumbnail directory: %w", err)
+	}
+	// written atomically, pickers may be reading thumbnails at any time
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85}); err != nil {
+		return fmt.Errorf("encoding thumbnail: %w", err)
+	}
+	return writeFileAtomic(dst, buf.Bytes(), 0644)
+}
+
+// deleteThumbnails removes all thumbnails of the wallpaper.
+func (w *Walls) deleteThumbnails(ctx context.Context, wp *Wallpaper) error {
+	paths := []string{wp.ThumbnailPath(ctx, "")}
+	for effect := range w.Config.Effects.Effects {
+		paths = append(paths, wp.ThumbnailPath(ctx, effect))
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing thumbnail %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// fsckThumbs checks the thumbnail cache for thumbnails of effects that are no
+// longer configured and thumbnails no wallpaper refers to.
+func (w *Walls) fsckThumbs(ctx context.Context, report *FsckReport, repairIf func(func() error) func() error) error {
+	dir := filepath.Join(w.Config.Storage.Cache, thumbsCacheDir)
+	expected := make(map[string]bool)
+	for _, wp := range w.Store.Wallpapers {
+		expected[wp.ThumbnailPath(ctx, "")] = true
+		for effect := range w.Config.Effects.Effects {
+			expected[wp.ThumbnailPath(ctx, effect)] = true
+		}
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == dir {
+				return nil
+			}
+			if _, ok := w.Config.Effects.Effects[d.Name()]; !ok || filepath.Dir(path) != dir {
+				report.add(&FsckProblem{
+					Kind:    FsckStaleEffect,
+					Path:    path,
+					Message: fmt.Sprintf("thumbnails for effect %s, which is not configured", d.Name()),
+				}, repairIf(func() error {
+					return os.RemoveAll(path)
+				}))
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !expected[path] {
+			report.add(&FsckProblem{
+				Kind:    FsckOrphanCache,
+				Path:    path,
+				Message: "thumbnail is not referenced by any wallpaper",
+			}, repairIf(func() error {
+				return os.Remove(path)
+			}))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+
+	"github.com/gen2brain/avif"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+)
+
+// needsTransform reports whether an image has to be re-encoded before it is
+// written to the store, because it is to be rotated upright or is larger than
+// import.max-resolution.
+func (w *Walls) needsTransform(info *imageInfo) bool {
+	if info.Orientation > OrientationNormal && w.Config.Import.Orientation == OrientationRotate {
+		return true
+	}
+	return exceedsResolution(info.Resolution, w.Config.Import.MaxResolution)
+}
+
+// transformImage rotates the image upright and scales it down to fit
+// import.max-resolution, encoding it in its original format if possible. The
+// result carries no EXIF data, so it is always rotated upright.
+func (w *Walls) transformImage(data []byte, info *imageInfo) ([]byte, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+
+	img = applyOrientation(img, info.Orientation)
+	if limit := w.Config.Import.MaxResolution; exceedsResolution(info.Resolution, limit) {
+		size := fitResolution(info.Resolution, limit)
+		logger.Debugf("scaling image from %s down to %s", info.Resolution, size)
+		scaled := image.NewNRGBA(image.Rect(0, 0, size.Width, size.Height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+		img = scaled
+	}
+
+	var buf bytes.Buffer
+	err = encodeImage(&buf, img, format)
+	if errors.Is(err, errNoEncoder) {
+		fallback := "png"
+		if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+			fallback = "jpeg"
+		}
+		logger.Debugf("cannot encode %s, storing image as %s", format, fallback)
+		buf.Reset()
+		err = encodeImage(&buf, img, fallback)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encoding image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// exceedsResolution reports whether res doesn't fit within limit in either
+// orientation. A zero limit is never exceeded.
+func exceedsResolution(res, limit Resolution) bool {
+	if limit.Width <= 0 || limit.Height <= 0 {
+		return false
+	}
+	maxLong, maxShort := maxMin(limit.Width, limit.Height)
+	long, short := maxMin(res.Width, res.Height)
+	return long > maxLong || short > maxShort
+}
+
+// fitResolution scales res down, keeping its aspect ratio, so it fits within
+// limit with limit oriented the same way as res.
+func fitResolution(res, limit Resolution) Resolution {
+	maxLong, maxShort := maxMin(limit.Width, limit.Height)
+	long, short := maxMin(res.Width, res.Height)
+	scale := min(float64(maxLong)/float64(long), float64(maxShort)/float64(short), 1)
+	return Resolution{
+		Width:  max(1, int(float64(res.Width)*scale+0.5)),
+		Height: max(1, int(float64(res.Height)*scale+0.5)),
+	}
+}
+
+func maxMin(a, b int) (int, int) {
+	return max(a, b), min(a, b)
+}
+
+var errNoEncoder = errors.New("encoding this format is not supported")
+
+// encodableFormats are the formats encodeImage can write.
+var encodableFormats = []string{"jpeg", "png", "tiff", "bmp", "avif"}
+
+// canEncode reports whether encodeImage can write format.
+func canEncode(format string) bool {
+	return slices.Contains(encodableFormats, format)
+}
+
+// encodeImage encodes img in the given format, as reported by
+// image.DecodeConfig.
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 95})
+	case "png":
+		return png.Encode(w, img)
+	case "tiff":
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	case "bmp":
+		return bmp.Encode(w, img)
+	case "avif":
+		return avif.Encode(w, img)
+	}
+	return fmt.Errorf("%s: %w", format, errNoEncoder)
+}
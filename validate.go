@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Validation actions, deciding what happens to an image that violates the
+// import.validate policy.
+const (
+	ValidateReject = "reject"
+	ValidateWarn   = "warn"
+)
+
+type ValidateConfig struct {
+	// ValidateReject or ValidateWarn
+	Action        string     `kdl:"action"`
+	MinResolution Resolution `kdl:"min-resolution"`
+	// Allowed range of width/height, 0 for no limit
+	MinAspect float64 `kdl:"min-aspect"`
+	MaxAspect float64 `kdl:"max-aspect"`
+	// Maximum file size such as 20M, see parseByteSize
+	MaxSize string `kdl:"max-size"`
+	// Allowed formats separated by spaces or commas, e.g. "jpeg png webp"
+	Formats string `kdl:"formats"`
+
+	maxSize int64
+	formats []string
+}
+
+// ValidationError is returned when adding an image rejected by the
+// import.validate policy.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("rejected by import policy: %s", strings.Join(e.Problems, ", "))
+}
+
+// compile validates the policy and prepares it for checking images.
+func (c *ValidateConfig) compile() error {
+	if c.Action == "" {
+		c.Action = ValidateReject
+	}
+	if c.Action != ValidateReject && c.Action != ValidateWarn {
+		return fmt.Errorf("action: must be %s or %s", ValidateReject, ValidateWarn)
+	}
+	if c.MinResolution.Width < 0 || c.MinResolution.Height < 0 {
+		return fmt.Errorf("min-resolution: must not be negative")
+	}
+	if c.MinAspect < 0 || c.MaxAspect < 0 || (c.MaxAspect > 0 && c.MinAspect > c.MaxAspect) {
+		return fmt.Errorf("min-aspect, max-aspect: invalid range %g-%g", c.MinAspect, c.MaxAspect)
+	}
+	if c.MaxSize != "" {
+		size, err := parseByteSize(c.MaxSize)
+		if err != nil {
+			return fmt.Errorf("max-size: %w", err)
+		}
+		c.maxSize = size
+	}
+	c.formats = nil
+	for _, format := range strings.FieldsFunc(strings.ToLower(c.Formats), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		c.formats = append(c.formats, format)
+	}
+	return nil
+}
+
+// check checks the probed image named name against the policy. Problems are
+// logged if the action is ValidateWarn, and returned as a *ValidationError if
+// it is ValidateReject.
+func (c *ValidateConfig) check(name string, info *imageInfo) error {
+	var problems []string
+	res := info.Resolution
+	if res.Width < c.MinResolution.Width || res.Height < c.MinResolution.Height {
+		problems = append(problems, fmt.Sprintf("resolution %s is below %s", res, c.MinResolution))
+	}
+	if res.Height > 0 {
+		ratio := float64(res.Width) / float64(res.Height)
+		if ratio < c.MinAspect || (c.MaxAspect > 0 && ratio > c.MaxAspect) {
+			problems = append(problems, fmt.Sprintf("aspect ratio %.2f is outside %s", ratio, c.aspectRange()))
+		}
+	}
+	if c.maxSize > 0 && info.Size > c.maxSize {
+		problems = append(problems, fmt.Sprintf("size %d bytes is over %s", info.Size, c.MaxSize))
+	}
+	if len(c.formats) > 0 && !slices.Contains(c.formats, info.Format) {
+		problems = append(problems, fmt.Sprintf("format %s is not one of %s", info.Format, strings.Join(c.formats, ", ")))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	if c.Action == ValidateWarn {
+		for _, problem := range problems {
+			logger.Warnf("%s: %s", name, problem)
+		}
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+func (c *ValidateConfig) aspectRange() string {
+	if c.MaxAspect == 0 {
+		return fmt.Sprintf("%g or wider", c.MinAspect)
+	}
+	return fmt.Sprintf("%g-%g", c.MinAspect, c.MaxAspect)
+}
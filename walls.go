@@ -1,17 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
-	"math/rand/v2"
+	"maps"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
@@ -27,19 +32,31 @@ import (
 type Walls struct {
 	Config *Config
 	Store  *Store
+
+	lockFile *os.File
+	// syscall.LOCK_EX or syscall.LOCK_SH while lockFile is held
+	lockHow int
+	// Upgrade outdated stores when loading them
+	migrate bool
+	state   *State
 }
 
 type Store struct {
+	// Version of the store layout, see storeVersion
+	Version    int          `kdl:"version" json:"version"`
 	Wallpapers []*Wallpaper `kdl:"wallpaper,multiple" json:"wallpapers"`
 }
 
 type Wallpaper struct {
 	// Unique identifier for the wallpaper
 	Id string `kdl:",argument" json:"id"`
-	// Path (relative to the data directory) to the wallpaper file
+	// Path (relative to the data directory) to the wallpaper file, or the
+	// absolute path of the original for referenced wallpapers
 	Path string `kdl:"path" json:"source_path"`
 	// Original filename of the wallpaper when it was added
 	OriginalFilename string `kdl:"original" json:"original_filename"`
+	// Absolute directory the wallpaper was added from, if it was added from a file
+	OriginalDir string `kdl:"original-dir" json:"original_dir"`
 	// The resolution of the wallpaper
 	Resolution Resolution `kdl:"resolution" json:"resolution"`
 	// The mime type of the wallpaper (e.g. image/jpeg)
@@ -48,6 +65,51 @@ type Wallpaper struct {
 	Enabled bool `kdl:"enabled" json:"enabled"`
 	// Tags associated with the wallpaper
 	Tags map[string]string `kdl:"tags" json:"tags"`
+	// SHA-256 hash of the wallpaper file, used to detect duplicates
+	Hash string `kdl:"hash" json:"hash"`
+	// Relative chance of being picked by the weighted strategy (0 means 1)
+	Weight int `kdl:"weight" json:"weight"`
+	// Rating from 1 to 5, or 0 if unrated
+	Rating int `kdl:"rating" json:"rating"`
+	// Whether the wallpaper is a favorite
+	Favorite bool `kdl:"favorite" json:"favorite"`
+	// URL the wallpaper was downloaded from, if any
+	Source string `kdl:"source" json:"source_url"`
+	// How the file was imported (see ImportCopy etc.), empty means copy
+	Mode string `kdl:"mode" json:"mode"`
+	// EXIF orientation of the file (see OrientationNormal etc.), 0 if none.
+	// Resolution is that of the image displayed upright.
+	Orientation int `kdl:"orientation" json:"orientation"`
+}
+
+// Import modes, deciding how an added file ends up in the store.
+const (
+	// Copy the file into the sources directory
+	ImportCopy = "copy"
+	// Hard link the file into the sources directory
+	ImportHardlink = "hardlink"
+	// Symlink the file into the sources directory
+	ImportSymlink = "symlink"
+	// Leave the file where it is and store its absolute path
+	ImportReference = "reference"
+)
+
+var importModes = []string{ImportCopy, ImportHardlink, ImportSymlink, ImportReference}
+
+// ImportMode returns how the wallpaper was imported.
+func (wp *Wallpaper) ImportMode() string {
+	if wp.Mode == "" {
+		return ImportCopy
+	}
+	return wp.Mode
+}
+
+// EffectiveWeight returns the weight used by the weighted strategy.
+func (wp *Wallpaper) EffectiveWeight() float64 {
+	if wp.Weight <= 0 {
+		return 1
+	}
+	return float64(wp.Weight)
 }
 
 type Resolution struct {
@@ -55,26 +117,40 @@ type Resolution struct {
 	Height int `json:"height"`
 }
 
-func loadWalls(ctx context.Context, configPath string) (context.Context, error) {
+// loadWalls loads the config and store, locking the store according to mode.
+// If migrate is set, an outdated store is upgraded to the current store
+// version whenever it is loaded.
+func loadWalls(ctx context.Context, configPath string, migrate bool, mode lockMode) (context.Context, error) {
 	config, err := loadConfig(configPath)
 	if err != nil {
 		return ctx, err
 	}
-	w := &Walls{Config: config}
-	err = w.Init(ctx)
+	w := &Walls{Config: config, migrate: migrate}
+	err = w.Init(ctx, mode)
 	if err != nil {
 		return ctx, err
 	}
+	if mode == lockLoad {
+		w.Unlock()
+	}
 	ctx = setWalls(ctx, w)
 	return ctx, nil
 }
 
-func (w *Walls) Init(ctx context.Context) error {
+func (w *Walls) Init(ctx context.Context, mode lockMode) error {
 	logger.Debugf("walls initializing")
 	err := w.CreateDirs(ctx)
 	if err != nil {
 		return fmt.Errorf("creating directories: %w", err)
 	}
+	if mode == lockExclusive {
+		err = w.Lock(ctx)
+	} else {
+		err = w.LockShared(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("locking store: %w", err)
+	}
 	err = w.LoadStore(ctx)
 	if err != nil {
 		return fmt.Errorf("loading store: %w", err)
@@ -106,74 +182,337 @@ func (w *Walls) CreateDirs(ctx context.Context) error {
 	return nil
 }
 
-func (w *Walls) AddWallpaper(ctx context.Context, path string, id string) (*Wallpaper, error) {
+type AddOptions struct {
+	// The id of the new wallpaper. If empty, an id is derived from the filename.
+	Id string
+	// Add the wallpaper even if an identical image is already in the store
+	AllowDuplicate bool
+	// Filename to record and derive the id from, instead of the file's name
+	Filename string
+	// URL the file was downloaded from, if any
+	Source string
+	// Import mode, the configured import mode if empty
+	Mode string
+	// Tags to give the new wallpaper
+	Tags map[string]string
+	// Add the wallpaper even if it violates import.validate
+	SkipValidation bool
+	// Policy for a taken id derived from the filename, import.on-conflict if
+	// empty
+	OnConflict string
+}
+
+func (w *Walls) AddWallpaper(ctx context.Context, path string, opts AddOptions) (*Wallpaper, error) {
 	logger.Debugf("adding wallpaper %s", path)
-	f, err := os.Open(path)
+	// read and decode image
+	info, err := probeImageFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("opening wallpaper file %s: %w", path, err)
+		return nil, fmt.Errorf("reading wallpaper file %s: %w", path, err)
 	}
-	defer f.Close()
+	return w.addProbed(ctx, path, info, opts)
+}
 
-	if id == "" {
-		base := filepath.Base(path)
-		ext := filepath.Ext(base)
-		id = strings.TrimSuffix(base, ext)
-		id = strings.ReplaceAll(id, " ", "_")
-		logger.Debugf("using id %s from filename", id)
+// DuplicateError is returned when adding an image that is already in the store.
+type DuplicateError struct {
+	// Id of the wallpaper with the same contents
+	Id string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("wallpaper is identical to existing wallpaper %s (specify --allow-duplicate to add it anyway)", e.Id)
+}
+
+// addProbed adds the image at path, which has already been probed, to the
+// store.
+func (w *Walls) addProbed(ctx context.Context, path string, info *imageInfo, opts AddOptions) (*Wallpaper, error) {
+	if opts.Filename == "" {
+		opts.Filename = filepath.Base(path)
+	}
+	if opts.Mode == "" {
+		opts.Mode = w.Config.Import.Mode
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolving path: %w", err)
+	}
+	dir := filepath.Dir(absPath)
+	if opts.Source != "" {
+		// downloaded to the cache
+		dir = ""
 	}
 
-	// read and decode image
-	img, format, err := image.DecodeConfig(f)
+	if err := w.validate(info, opts); err != nil {
+		return nil, err
+	}
+	if w.needsTransform(info) {
+		// checked above, the stored copy may differ in size and format
+		opts.SkipValidation = true
+		if opts.Mode != ImportCopy {
+			logger.Warnf("%s: storing a rotated or scaled copy instead of using import mode %s", path, opts.Mode)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading image: %w", err)
+		}
+		return w.addData(ctx, data, dir, opts)
+	}
+
+	wallpaper, err := w.newWallpaper(ctx, info, dir, opts)
 	if err != nil {
-		return nil, fmt.Errorf("decoding image: %w", err)
+		return nil, err
+	}
+
+	// write image to store
+	switch opts.Mode {
+	case ImportCopy:
+		logger.Debugf("writing wallpaper to store at %s", wallpaper.Path)
+		if err := copyFile(path, wallpaper.Path); err != nil {
+			return nil, fmt.Errorf("copying image to store: %w", err)
+		}
+	case ImportHardlink:
+		logger.Debugf("linking %s to %s", wallpaper.Path, absPath)
+		if err := os.Link(absPath, wallpaper.Path); err != nil {
+			if errors.Is(err, syscall.EXDEV) {
+				return nil, fmt.Errorf("hard linking image into store: %s is on a different filesystem than the store (use --mode symlink or reference)", path)
+			}
+			return nil, fmt.Errorf("hard linking image into store: %w", err)
+		}
+	case ImportSymlink:
+		logger.Debugf("symlinking %s to %s", wallpaper.Path, absPath)
+		if err := os.Symlink(absPath, wallpaper.Path); err != nil {
+			return nil, fmt.Errorf("symlinking image into store: %w", err)
+		}
+	case ImportReference:
+		logger.Debugf("referencing wallpaper at %s", absPath)
+		wallpaper.Path = absPath
+	default:
+		return nil, fmt.Errorf("unknown import mode %s (available: %s)", opts.Mode, strings.Join(importModes, ", "))
 	}
 
-	pathInStore := filepath.Join(w.Config.Storage.Sources, "sources", id+"."+format)
+	w.Store.Wallpapers = append(w.Store.Wallpapers, wallpaper)
+
+	return wallpaper, nil
+}
+
+// addData adds an image held in memory from dir to the store, rotating and
+// scaling it first if configured.
+func (w *Walls) addData(ctx context.Context, data []byte, dir string, opts AddOptions) (*Wallpaper, error) {
+	opts.Mode = ImportCopy
+	info, err := probeImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Filename == "" {
+		opts.Filename = fmt.Sprintf("stdin-%s.%s", info.Hash[:8], info.Format)
+	}
+	// validate the image as given, not the rotated or scaled copy
+	if err := w.validate(info, opts); err != nil {
+		return nil, err
+	}
+	if w.needsTransform(info) {
+		if data, err = w.transformImage(data, info); err != nil {
+			return nil, err
+		}
+		if info, err = probeImage(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	wallpaper, err := w.newWallpaper(ctx, info, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("writing wallpaper to store at %s", wallpaper.Path)
+	if err := writeFileAtomic(wallpaper.Path, data, 0644); err != nil {
+		return nil, fmt.Errorf("writing image to store: %w", err)
+	}
+
+	w.Store.Wallpapers = append(w.Store.Wallpapers, wallpaper)
+
+	return wallpaper, nil
+}
+
+// validate checks an image named opts.Filename against import.validate,
+// unless opts.SkipValidation is set.
+func (w *Walls) validate(info *imageInfo, opts AddOptions) error {
+	if opts.SkipValidation {
+		return nil
+	}
+	return w.Config.Import.Validate.check(opts.Filename, info)
+}
+
+// newWallpaper creates the store entry for a probed and validated image named
+// opts.Filename from dir, checking that it isn't a duplicate and finding a
+// free id. It is tagged by the import rules and opts.Tags.
+func (w *Walls) newWallpaper(ctx context.Context, info *imageInfo, dir string, opts AddOptions) (*Wallpaper, error) {
+	if dup := w.WallpaperByHash(ctx, info.Hash); dup != nil {
+		if !opts.AllowDuplicate {
+			return nil, &DuplicateError{Id: dup.Id}
+		}
+		logger.Warnf("wallpaper is identical to existing wallpaper %s, adding anyway", dup.Id)
+	}
+
+	storePath := func(id string) string {
+		return filepath.Join(w.Config.Storage.Sources, "sources", id+"."+info.Format)
+	}
+	taken := func(id string) bool {
+		if w.FindWallpaper(id) != nil {
+			return true
+		}
+		// an orphan left in the sources directory would be overwritten
+		_, err := os.Lstat(storePath(id))
+		return opts.Mode != ImportReference && err == nil
+	}
+
+	id := opts.Id
+	if id != "" {
+		if err := validateId(id); err != nil {
+			return nil, err
+		}
+		if taken(id) {
+			return nil, fmt.Errorf("a wallpaper with the id %s already exists, aborting\n(specify a different id with --id)", id)
+		}
+	} else {
+		policy := opts.OnConflict
+		if policy == "" {
+			policy = w.Config.Import.OnConflict
+		}
+		var err error
+		id, err = resolveIdConflict(slugify(strings.TrimSuffix(opts.Filename, filepath.Ext(opts.Filename))), info.Hash, policy, taken)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debugf("using id %s from filename", id)
+	}
 
 	wallpaper := &Wallpaper{
 		Id:               id,
-		Path:             pathInStore,
-		OriginalFilename: filepath.Base(path),
-		MimeType:         "image/" + format,
+		Path:             storePath(id),
+		OriginalFilename: opts.Filename,
+		OriginalDir:      dir,
+		MimeType:         info.MimeType,
+		Resolution:       info.Resolution,
+		Enabled:          true,
+		Hash:             info.Hash,
+		Source:           opts.Source,
+		Mode:             opts.Mode,
+		Orientation:      info.Orientation,
+	}
+	tags := w.RuleTags(wallpaper)
+	maps.Copy(tags, opts.Tags)
+	if len(tags) > 0 {
+		wallpaper.Tags = tags
+	}
+
+	return wallpaper, nil
+}
+
+// imageInfo holds the metadata walls records about an image file.
+type imageInfo struct {
+	Format     string
+	MimeType   string
+	Resolution Resolution
+	Hash       string
+	// Size of the file in bytes
+	Size int64
+	// EXIF orientation, 0 if there is none
+	Orientation int
+}
+
+// probeImage decodes the image header and hashes and measures the contents of
+// r. The resolution is that of the image displayed upright according to its
+// EXIF orientation. r is left at EOF.
+func probeImage(r io.ReadSeeker) (*imageInfo, error) {
+	img, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking image: %w", err)
+	}
+	orientation, err := exifOrientation(r, format)
+	if err != nil {
+		// the image itself decoded fine, so just ignore broken metadata
+		logger.Debugf("reading exif orientation: %s", err)
+	}
+	if orientationSwapsAxes(orientation) {
+		img.Width, img.Height = img.Height, img.Width
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking image: %w", err)
+	}
+	hash, err := hashReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("hashing image: %w", err)
+	}
+	size, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("seeking image: %w", err)
+	}
+	return &imageInfo{
+		Format:   format,
+		MimeType: "image/" + format,
 		Resolution: Resolution{
 			Width:  img.Width,
 			Height: img.Height,
 		},
-		Enabled: true,
+		Hash:        hash,
+		Size:        size,
+		Orientation: orientation,
+	}, nil
+}
+
+func probeImageFile(path string) (*imageInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
+	return probeImage(f)
+}
 
-	exists := false
+// WallpaperByHash returns the wallpaper whose file has the given hash, or nil if
+// there is none. Wallpapers stored without a hash are hashed (and updated) on
+// the fly.
+func (w *Walls) WallpaperByHash(ctx context.Context, hash string) *Wallpaper {
 	for _, wp := range w.Store.Wallpapers {
-		if wp.Id == wallpaper.Id {
-			exists = true
-			break
+		if wp.Hash == "" {
+			h, err := hashFile(wp.Path)
+			if err != nil {
+				logger.Warnf("hashing wallpaper %s: %s", wp.Id, err)
+				continue
+			}
+			logger.Debugf("computed missing hash for wallpaper %s: %s", wp.Id, h)
+			wp.Hash = h
+		}
+		if wp.Hash == hash {
+			return wp
 		}
 	}
-	if exists {
-		return nil, fmt.Errorf("a wallpaper with the id %s already exists, aborting\n(specify a different id with --id or change the filename)", wallpaper.Id)
+	return nil
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	// write image to store
-	f.Seek(0, io.SeekStart)
-	destination, err := os.Create(pathInStore)
-	logger.Debugf("writing wallpaper to store at %s", pathInStore)
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("creating store file %s: %w", pathInStore, err)
-	}
-	defer destination.Close()
-	if _, err := io.Copy(destination, f); err != nil {
-		return nil, fmt.Errorf("copying image to store: %w", err)
+		return "", err
 	}
-
-	w.Store.Wallpapers = append(w.Store.Wallpapers, wallpaper)
-
-	return wallpaper, nil
+	defer f.Close()
+	return hashReader(f)
 }
 
 func (w *Walls) DeleteWallpaper(ctx context.Context, id string) error {
-	for i, wp := range w.Store.Wallpapers {
+	for _, wp := range w.Store.Wallpapers {
 		if wp.Id == id {
-			w.Store.Wallpapers = append(w.Store.Wallpapers[:i], w.Store.Wallpapers[i+1:]...)
+			w.removeFromStore(wp)
 			return w.deleteFromDisk(ctx, wp)
 		}
 	}
@@ -181,10 +520,32 @@ func (w *Walls) DeleteWallpaper(ctx context.Context, id string) error {
 	return fmt.Errorf("wallpaper with id %s not found", id)
 }
 
+func (w *Walls) removeFromStore(wp *Wallpaper) {
+	w.Store.Wallpapers = slices.DeleteFunc(w.Store.Wallpapers, func(other *Wallpaper) bool {
+		return other == wp
+	})
+}
+
+// deleteFromDisk removes the wallpaper's file from the sources directory and
+// its effect outputs from the cache. Referenced originals are left alone; for
+// links only the link is removed.
 func (w *Walls) deleteFromDisk(ctx context.Context, wp *Wallpaper) error {
-	logger.Debugf("deleting wallpaper %s: deleting %s", wp.Id, wp.Path)
-	if err := os.Remove(wp.Path); err != nil {
-		return fmt.Errorf("removing wallpaper file %s: %w", wp.Path, err)
+	if wp.ImportMode() == ImportReference {
+		logger.Debugf("deleting wallpaper %s: keeping referenced file %s", wp.Id, wp.Path)
+	} else {
+		logger.Debugf("deleting wallpaper %s: deleting %s", wp.Id, wp.Path)
+		if err := os.Remove(wp.Path); err != nil {
+			return fmt.Errorf("removing wallpaper file %s: %w", wp.Path, err)
+		}
+	}
+	return w.deleteCached(ctx, wp)
+}
+
+// deleteCached removes all effect outputs and thumbnails for the wallpaper
+// from the cache.
+func (w *Walls) deleteCached(ctx context.Context, wp *Wallpaper) error {
+	if err := w.deleteThumbnails(ctx, wp); err != nil {
+		return err
 	}
 	for effect, _ := range w.Config.Effects.Effects {
 		logger.Debugf("deleting effect %s for wallpaper %s: deleting %s", effect, wp.Id, wp.PathWithEffect(ctx, effect))
@@ -198,9 +559,14 @@ func (w *Walls) deleteFromDisk(ctx context.Context, wp *Wallpaper) error {
 }
 
 func (w *Walls) PrecacheAll(ctx context.Context, force bool) error {
+	return w.PrecacheWallpapers(ctx, w.Store.Wallpapers, force)
+}
+
+// PrecacheWallpapers applies all effects to the given wallpapers in parallel.
+func (w *Walls) PrecacheWallpapers(ctx context.Context, wps []*Wallpaper, force bool) error {
 	var wg sync.WaitGroup
-	wg.Add(len(w.Store.Wallpapers))
-	for _, wp := range w.Store.Wallpapers {
+	wg.Add(len(wps))
+	for _, wp := range wps {
 		go func(wp *Wallpaper) {
 			defer wg.Done()
 			if err := w.precacheWallpaper(ctx, wp, force); err != nil {
@@ -209,7 +575,7 @@ func (w *Walls) PrecacheAll(ctx context.Context, force bool) error {
 		}(wp)
 	}
 
-	logger.Infof("precaching %d wallpapers with %d effects...", len(w.Store.Wallpapers), len(w.Config.Effects.Effects))
+	logger.Infof("precaching %d wallpapers with %d effects...", len(wps), len(w.Config.Effects.Effects))
 	wg.Wait()
 	logger.Infof("precaching complete")
 
@@ -230,16 +596,19 @@ func (w *Walls) precacheWallpaper(ctx context.Context, wp *Wallpaper, force bool
 	wg.Add(len(w.Config.Effects.Effects))
 	errors := false
 	effects := w.Config.Effects.Effects
-	for effect, command := range effects {
-		go func(effect string, command []string) {
+	for name, effect := range effects {
+		go func(name string, effect Effect) {
 			defer wg.Done()
-			if err := w.applyEffect(ctx, wp, effect, command, force); err != nil {
-				logger.Errorf("applying effect %s: %w", effect, err)
+			if err := w.applyEffect(ctx, wp, name, &effect, force); err != nil {
+				logger.Errorf("applying effect %s: %w", name, err)
 				errors = true
 			}
-		}(effect, command)
+		}(name, effect)
 	}
 	wg.Wait()
+	if err := w.updateThumbnails(ctx, wp, force); err != nil {
+		logger.Errorf("generating thumbnails for %s: %w", wp.Id, err)
+	}
 	if errors {
 		return fmt.Errorf("applying effects failed, see above for details")
 	} else {
@@ -247,11 +616,20 @@ func (w *Walls) precacheWallpaper(ctx context.Context, wp *Wallpaper, force bool
 	}
 }
 
+// PathWithEffect returns the path of the wallpaper's cached output for the
+// effect. It is named after the id, since referenced files in different
+// directories may share a name. Built-in effects write PNG if the wallpaper's
+// format can't be encoded, so their output then has a .png extension.
 func (wp *Wallpaper) PathWithEffect(ctx context.Context, effect string) string {
-	return filepath.Join(getWalls(ctx).Config.Storage.Cache, effect, filepath.Base(wp.Path))
+	config := getWalls(ctx).Config
+	ext := filepath.Ext(wp.Path)
+	if e, ok := config.Effects.Effects[effect]; ok && e.Builtin != "" && !canEncode(strings.TrimPrefix(wp.MimeType, "image/")) {
+		ext = ".png"
+	}
+	return filepath.Join(config.Storage.Cache, effect, wp.Id+ext)
 }
 
-func (w *Walls) applyEffect(ctx context.Context, wp *Wallpaper, effect string, command []string, force bool) error {
+func (w *Walls) applyEffect(ctx context.Context, wp *Wallpaper, effect string, e *Effect, force bool) error {
 	outputPath := wp.PathWithEffect(ctx, effect)
 	effectDir := filepath.Dir(outputPath)
 	if err := os.MkdirAll(effectDir, 0755); err != nil {
@@ -271,16 +649,22 @@ func (w *Walls) applyEffect(ctx context.Context, wp *Wallpaper, effect string, c
 		logger.Debugf("applying effect %s for %s", effect, wp.Id)
 	}
 
-	commandCopy := make([]string, len(command))
-	copy(commandCopy, command)
-	command = commandCopy
+	if e.Builtin != "" {
+		if err := applyBuiltinEffect(wp, e, outputPath); err != nil {
+			return fmt.Errorf("applying built-in effect %s: %w", effect, err)
+		}
+		return nil
+	}
+
+	command := make([]string, len(e.Command))
+	copy(command, e.Command)
 
 	// replace %i and %o with the input and output paths
 	for i, arg := range command {
 		if arg == "%i" {
-			command[i] = wp.Path
+			command[i] = shellQuote(wp.Path)
 		} else if arg == "%o" {
-			command[i] = outputPath
+			command[i] = shellQuote(outputPath)
 		}
 	}
 
@@ -296,39 +680,91 @@ func (w *Walls) applyEffect(ctx context.Context, wp *Wallpaper, effect string, c
 	return nil
 }
 
-func (w *Walls) RandomWallpaper(ctx context.Context) *Wallpaper {
-	enabled := make([]*Wallpaper, 0, len(w.Store.Wallpapers))
+// FindWallpaper returns the wallpaper with the given id, or nil if there is
+// none.
+func (w *Walls) FindWallpaper(id string) *Wallpaper {
 	for _, wp := range w.Store.Wallpapers {
-		if wp.Enabled {
-			enabled = append(enabled, wp)
+		if wp.Id == id {
+			return wp
 		}
 	}
-	if len(enabled) == 0 {
-		return nil
-	}
-	n := rand.IntN(len(enabled))
-	return enabled[n]
-
+	return nil
 }
 
-func (w *Walls) SetWallpaper(ctx context.Context, id string) error {
-	var wp *Wallpaper
-	for _, w := range w.Store.Wallpapers {
-		if w.Id == id {
-			wp = w
-			break
+// MatchWallpapers resolves a list of wallpaper ids and glob patterns (e.g.
+// "forest-*") to wallpapers. Each id and pattern must match at least one
+// wallpaper.
+func (w *Walls) MatchWallpapers(patterns []string) ([]*Wallpaper, error) {
+	var wps []*Wallpaper
+	seen := make(map[*Wallpaper]bool)
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			wp := w.FindWallpaper(pattern)
+			if wp == nil {
+				return nil, fmt.Errorf("wallpaper with id %s not found", pattern)
+			}
+			if !seen[wp] {
+				seen[wp] = true
+				wps = append(wps, wp)
+			}
+			continue
+		}
+
+		matched := false
+		for _, wp := range w.Store.Wallpapers {
+			ok, err := path.Match(pattern, wp.Id)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+			}
+			if ok {
+				matched = true
+				if !seen[wp] {
+					seen[wp] = true
+					wps = append(wps, wp)
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no wallpapers match %s", pattern)
 		}
 	}
+	return wps, nil
+}
 
+// SetWallpaper sets the wallpaper and records it in the history.
+func (w *Walls) SetWallpaper(ctx context.Context, id string) error {
+	wp := w.FindWallpaper(id)
 	if wp == nil {
 		return fmt.Errorf("wallpaper with id %s not found", id)
 	}
 
+	state, err := w.applyWallpaper(ctx, wp)
+	if err != nil {
+		return err
+	}
+
+	if err := w.RecordHistory(ctx, state); err != nil {
+		logger.Errorf("recording history: %w", err)
+	}
+
+	return nil
+}
+
+// applyWallpaper runs the set commands for the wallpaper and records it as the
+// current wallpaper.
+func (w *Walls) applyWallpaper(ctx context.Context, wp *Wallpaper) (*State, error) {
+	id := wp.Id
 	if len(w.Config.Behavior.Set) == 0 {
-		return fmt.Errorf("no wallpaper set behaviors configured")
+		return nil, fmt.Errorf("no wallpaper set behaviors configured")
+	}
+
+	state := &State{
+		Id:    wp.Id,
+		SetAt: time.Now(),
+		Pids:  []int{},
 	}
 
-	for _, set := range w.Config.Behavior.Set {
+	for i, set := range w.Config.Behavior.Set {
 		path := wp.Path
 		effect := w.Config.Effects.Default
 		if set.Effect != "" {
@@ -340,7 +776,7 @@ func (w *Walls) SetWallpaper(ctx context.Context, id string) error {
 				logger.Debugf("effect %s not precached for wallpaper %s, precaching...", effect, id)
 				err = w.precacheWallpaper(ctx, wp, false)
 				if err != nil {
-					return fmt.Errorf("precaching wallpaper: %w", err)
+					return nil, fmt.Errorf("precaching wallpaper: %w", err)
 				}
 			}
 		}
@@ -351,16 +787,21 @@ func (w *Walls) SetWallpaper(ctx context.Context, id string) error {
 		// replace %w with the wallpaper path
 		for i, arg := range command {
 			if arg == "%w" {
-				command[i] = path
+				command[i] = shellQuote(path)
 			}
 		}
 		commandStr := strings.Join(command, " ")
 		logger.Debugf("exec set: %s", commandStr)
 		cmd := exec.CommandContext(ctx, "sh", "-c", commandStr)
 		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("running set command: %w", err)
+			return nil, fmt.Errorf("running set command: %w", err)
 		}
 		logger.Debugf("started process %d", cmd.Process.Pid)
+		state.Pids = append(state.Pids, cmd.Process.Pid)
+		if i == 0 {
+			state.Effect = effect
+			state.Path = path
+		}
 
 		if set.Pkill != "" {
 			slept := false
@@ -368,7 +809,7 @@ func (w *Walls) SetWallpaper(ctx context.Context, id string) error {
 			logger.Debugf("killing processes named %s except for %d", set.Pkill, ownPid)
 			procs, err := procfs.AllProcs()
 			if err != nil {
-				return fmt.Errorf("listing processes: %w", err)
+				return nil, fmt.Errorf("listing processes: %w", err)
 			}
 			logger.Debugf("examining %d processes", len(procs))
 			for _, p := range procs {
@@ -389,11 +830,11 @@ func (w *Walls) SetWallpaper(ctx context.Context, id string) error {
 					}
 					proc, err := os.FindProcess(p.PID)
 					if err != nil {
-						return fmt.Errorf("finding process: %w", err)
+						return nil, fmt.Errorf("finding process: %w", err)
 					}
 					err = proc.Signal(syscall.SIGTERM)
 					if err != nil {
-						return fmt.Errorf("sending signal: %w", err)
+						return nil, fmt.Errorf("sending signal: %w", err)
 					}
 					logger.Debugf("killed process %d, exe %s", p.PID, exe)
 				}
@@ -402,6 +843,17 @@ func (w *Walls) SetWallpaper(ctx context.Context, id string) error {
 
 	}
 
-	return nil
+	prev, err := w.State(ctx)
+	if err != nil {
+		logger.Warnf("%s", err)
+	}
+	if err := w.RecordStats(ctx, prev, state); err != nil {
+		logger.Errorf("recording stats: %w", err)
+	}
+	if err := w.WriteState(ctx, state); err != nil {
+		logger.Errorf("recording current wallpaper: %w", err)
+	}
+
+	return state, nil
 
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testWalls returns a Walls with an empty store in a temporary directory,
+// validating imports against validate.
+func testWalls(t *testing.T, validate ValidateConfig) (context.Context, *Walls) {
+	t.Helper()
+	config := DefaultConfig()
+	dir := t.TempDir()
+	config.Storage = StorageConfig{
+		Sources: filepath.Join(dir, "data"),
+		Cache:   filepath.Join(dir, "cache"),
+		Runtime: filepath.Join(dir, "runtime"),
+	}
+	config.Import.MaxResolution = Resolution{Width: 32, Height: 32}
+	config.Import.Validate = validate
+	if err := config.Import.Validate.compile(); err != nil {
+		t.Fatal(err)
+	}
+	w := &Walls{Config: config, Store: &Store{}}
+	if err := w.CreateDirs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return setWalls(context.Background(), w), w
+}
+
+// TestAddValidatesOriginal adds a webp that is scaled down and, lacking a webp
+// encoder, stored as jpeg. Validation must see the webp as given.
+func TestAddValidatesOriginal(t *testing.T) {
+	const path = "testdata/blue-purple-pink.lossy.webp"
+
+	t.Run("rejected", func(t *testing.T) {
+		ctx, w := testWalls(t, ValidateConfig{Formats: "jpeg png", MaxSize: "2K"})
+		_, err := w.AddWallpaper(ctx, path, AddOptions{})
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("AddWallpaper error = %v, want a ValidationError", err)
+		}
+		problems := strings.Join(verr.Problems, "\n")
+		if !strings.Contains(problems, "format webp") || !strings.Contains(problems, "size") {
+			t.Errorf("problems = %q, want the format and size of the webp", verr.Problems)
+		}
+		if len(w.Store.Wallpapers) != 0 {
+			t.Errorf("rejected wallpaper was added")
+		}
+	})
+
+	t.Run("accepted", func(t *testing.T) {
+		ctx, w := testWalls(t, ValidateConfig{Formats: "webp", MinResolution: Resolution{Width: 64, Height: 64}})
+		wp, err := w.AddWallpaper(ctx, path, AddOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wp.MimeType != "image/jpeg" || exceedsResolution(wp.Resolution, Resolution{Width: 32, Height: 32}) {
+			t.Errorf("stored %s at %s, want a jpeg within 32x32", wp.MimeType, wp.Resolution)
+		}
+		if _, err := os.Stat(wp.Path); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("no-validate", func(t *testing.T) {
+		ctx, w := testWalls(t, ValidateConfig{Formats: "png"})
+		if _, err := w.AddWallpaper(ctx, path, AddOptions{SkipValidation: true}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestBuiltinEffectPath checks that built-in effects on a webp, which can't be
+// encoded, write a PNG with a matching extension.
+func TestBuiltinEffectPath(t *testing.T) {
+	ctx, w := testWalls(t, ValidateConfig{})
+	w.Config.Import.MaxResolution = Resolution{}
+	effect := Effect{Builtin: "blur"}
+	if err := effect.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	w.Config.Effects.Effects["blur"] = effect
+	w.Config.Effects.Effects["cmd"] = Effect{Command: []string{"cp", "%i", "%o"}}
+
+	wp, err := w.AddWallpaper(ctx, "testdata/blue-purple-pink.lossy.webp", AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := wp.PathWithEffect(ctx, "blur")
+	if filepath.Ext(path) != ".png" {
+		t.Errorf("blur output %s, want a .png extension", path)
+	}
+	if ext := filepath.Ext(wp.PathWithEffect(ctx, "cmd")); ext != ".webp" {
+		t.Errorf("command output extension %s, want .webp", ext)
+	}
+
+	if err := w.applyEffect(ctx, wp, "blur", &effect, false); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, format, err := image.DecodeConfig(f); err != nil || format != "png" {
+		t.Errorf("blur output decodes as %q (%v), want png", format, err)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const defaultSettleDelay = 2 * time.Second
+
+// WatchFolder is a directory watched by `walls watch`, along with how new
+// files in it are imported.
+type WatchFolder struct {
+	Path string `kdl:",argument"`
+	// Also watch subdirectories
+	Recursive bool `kdl:"recursive"`
+	// Precache effects for new wallpapers
+	Precache bool `kdl:"precache"`
+	// Import mode, import.mode if empty
+	Mode string `kdl:"mode"`
+	// Tags given to new wallpapers
+	Tags map[string]string `kdl:"tags"`
+}
+
+// watchExcludeSuffixes are extensions browsers and downloaders use for files
+// that are still being written; they are renamed once complete.
+var watchExcludeSuffixes = []string{".part", ".crdownload", ".download", ".partial", ".tmp"}
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_MODIFY |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_DELETE_SELF
+
+// Watcher notices new files in directories using inotify.
+type Watcher struct {
+	fd   int
+	file *os.File
+	// Watched directories by watch descriptor
+	dirs map[int32]*watchedDir
+}
+
+type watchedDir struct {
+	path   string
+	folder *WatchFolder
+}
+
+// pendingFile is a new file that is still being written.
+type pendingFile struct {
+	folder    *WatchFolder
+	lastEvent time.Time
+	size      int64
+	modTime   time.Time
+}
+
+func NewWatcher() (*Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("initializing inotify: %w", err)
+	}
+	// a non-blocking file goes through the runtime poller, so Close interrupts
+	// a pending Read. file.Fd() would make it blocking again, so keep fd.
+	return &Watcher{
+		fd:   fd,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int32]*watchedDir),
+	}, nil
+}
+
+func (wt *Watcher) Close() error {
+	return wt.file.Close()
+}
+
+// Add watches the folder, and its subdirectories if it is recursive.
+func (wt *Watcher) Add(folder *WatchFolder) error {
+	if !folder.Recursive {
+		return wt.addDir(folder.Path, folder)
+	}
+	return filepath.WalkDir(folder.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != folder.Path && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return wt.addDir(path, folder)
+	})
+}
+
+func (wt *Watcher) addDir(path string, folder *WatchFolder) error {
+	wd, err := syscall.InotifyAddWatch(wt.fd, path, watchMask)
+	if err != nil {
+		return fmt.Errorf("watching %s: %w", path, err)
+	}
+	logger.Debugf("watching %s", path)
+	wt.dirs[int32(wd)] = &watchedDir{path, folder}
+	return nil
+}
+
+type watchEvent struct {
+	wd   int32
+	mask uint32
+	name string
+}
+
+// read reads inotify events and sends them to events until the watcher is
+// closed.
+func (wt *Watcher) read(events chan<- watchEvent, errs chan<- error) {
+	defer close(events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := wt.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				errs <- fmt.Errorf("reading inotify events: %w", err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := string(buf[nameStart : nameStart+int(raw.Len)])
+			events <- watchEvent{raw.Wd, raw.Mask, strings.TrimRight(name, "\x00")}
+			offset = nameStart + int(raw.Len)
+		}
+	}
+}
+
+// Watch waits for new files in the watched directories and calls onSettled
+// with the files of a folder once they haven't changed for settle. It returns
+// when ctx is cancelled.
+func (wt *Watcher) Watch(ctx context.Context, settle time.Duration, onSettled func(folder *WatchFolder, paths []string)) error {
+	events := make(chan watchEvent)
+	errs := make(chan error, 1)
+	go wt.read(events, errs)
+	go func() {
+		<-ctx.Done()
+		wt.Close()
+	}()
+
+	pending := make(map[string]*pendingFile)
+	ticker := time.NewTicker(max(settle/4, 100*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				select {
+				case err := <-errs:
+					return err
+				default:
+					return nil
+				}
+			}
+			wt.handleEvent(ev, pending)
+		case <-ticker.C:
+			settled := make(map[*WatchFolder][]string)
+			for path, p := range pending {
+				if time.Since(p.lastEvent) < settle {
+					continue
+				}
+				stat, err := os.Stat(path)
+				if err != nil {
+					logger.Debugf("%s disappeared before it settled: %s", path, err)
+					delete(pending, path)
+					continue
+				}
+				if stat.Size() != p.size || !stat.ModTime().Equal(p.modTime) {
+					// still changing without generating events we watch
+					p.lastEvent, p.size, p.modTime = time.Now(), stat.Size(), stat.ModTime()
+					continue
+				}
+				delete(pending, path)
+				settled[p.folder] = append(settled[p.folder], path)
+			}
+			for folder, paths := range settled {
+				onSettled(folder, paths)
+			}
+		}
+	}
+}
+
+func (wt *Watcher) handleEvent(ev watchEvent, pending map[string]*pendingFile) {
+	dir, ok := wt.dirs[ev.wd]
+	if !ok {
+		return
+	}
+	if ev.mask&syscall.IN_DELETE_SELF != 0 {
+		logger.Warnf("watched directory %s was removed", dir.path)
+		delete(wt.dirs, ev.wd)
+		return
+	}
+	if ev.name == "" || strings.HasPrefix(ev.name, ".") {
+		return
+	}
+	path := filepath.Join(dir.path, ev.name)
+
+	if ev.mask&syscall.IN_ISDIR != 0 {
+		if ev.mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && dir.folder.Recursive {
+			if err := wt.addDir(path, dir.folder); err != nil {
+				logger.Errorf("%w", err)
+			}
+		}
+		return
+	}
+	if ev.mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0 {
+		delete(pending, path)
+		return
+	}
+	for _, suffix := range watchExcludeSuffixes {
+		if strings.HasSuffix(strings.ToLower(ev.name), suffix) {
+			return
+		}
+	}
+
+	p, ok := pending[path]
+	if !ok {
+		if ev.mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) == 0 {
+			// a change to an existing file
+			return
+		}
+		logger.Debugf("noticed new file %s", path)
+		p = &pendingFile{folder: dir.folder}
+		pending[path] = p
+	}
+	p.lastEvent = time.Now()
+	if stat, err := os.Stat(path); err == nil {
+		p.size, p.modTime = stat.Size(), stat.ModTime()
+	}
+}
+
+// ImportWatched imports files noticed by `walls watch`. The store is locked
+// and reloaded for the import only, so other walls commands can run while
+// watching.
+func (w *Walls) ImportWatched(ctx context.Context, folder *WatchFolder, paths []string) (*ImportResult, error) {
+	return w.ImportPaths(ctx, paths, ImportOptions{
+		AddOptions: AddOptions{
+			Mode: folder.Mode,
+			Tags: folder.Tags,
+		},
+	})
+}